@@ -0,0 +1,439 @@
+package main
+
+import (
+    . "./lib/gomaybe/cheats"
+    . "./lib/gomaybe/clock"
+    . "./lib/gomaybe/gameboy"
+    . "./lib/gomaybe/joypad"
+    . "./lib/gomaybe/movie"
+    . "./lib/gomaybe/rewind"
+    "bufio"
+    "bytes"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+)
+
+const consoleHelp = `Commands:
+  p          pause or resume
+  n          advance one frame while paused
+  f          toggle fast-forward
+  speed X    set the speed multiplier (0.5 is slow motion, 0 is unthrottled)
+  s [N]      save state to slot N (0-9, default is the current slot)
+  l [N]      load state from slot N
+  slots      list save state slots
+  r [N]      toggle rewinding, or step back N snapshots
+  j [B...]   hold buttons (a b select start up down left right), none to release
+  cheats     list cheats
+  cheat N    turn cheat N on or off
+  cheat CODE [NAME]
+             add a Game Genie (ABC-DEF-GHI) or GameShark (01VVAAAA) code
+  q          quit`
+
+const (
+    fastForwardSpeed = 4
+    stateSlots       = 10
+)
+
+type console struct {
+    gameBoy   *GameBoy
+    clock     *Clock
+    rewind    *Rewind
+    recorder  *Recorder
+    player    *Player
+    romPath   string
+    slot      int
+    rewinding bool
+    buttons   byte
+    verify    bool
+    failed    bool
+}
+
+// Lines typed on the console are delivered on the returned channel, which is
+// closed when the input ends
+func readCommands(in io.Reader) chan string {
+    commands := make(chan string)
+
+    go func() {
+        scanner := bufio.NewScanner(in)
+        for scanner.Scan() {
+            commands <- strings.TrimSpace(scanner.Text())
+        }
+        close(commands)
+    }()
+
+    return commands
+}
+
+// Returns false when the emulator should exit
+func (console *console) run(line string) bool {
+    args := strings.Fields(line)
+    clock := console.clock
+
+    switch args[0] {
+    case "p":
+        clock.TogglePause()
+        if clock.Paused() {
+            fmt.Printf("Paused at frame %d\n", clock.Frames())
+        } else {
+            fmt.Println("Resumed")
+        }
+    case "n":
+        if !clock.Paused() {
+            clock.Pause()
+        }
+        clock.AdvanceFrame()
+    case "f":
+        if clock.Speed() == 1 {
+            clock.SetSpeed(fastForwardSpeed)
+        } else {
+            clock.SetSpeed(1)
+        }
+        fmt.Printf("Speed: %gx\n", clock.Speed())
+    case "speed":
+        if len(args) < 2 {
+            fmt.Printf("Speed: %gx\n", clock.Speed())
+            break
+        }
+
+        if speed, err := strconv.ParseFloat(args[1], 64); err == nil && speed >= 0 {
+            clock.SetSpeed(speed)
+            fmt.Printf("Speed: %gx\n", clock.Speed())
+        } else {
+            fmt.Println("Invalid speed: " + args[1])
+        }
+    case "s", "l":
+        if args[0] == "l" && console.inMovie() {
+            break
+        }
+
+        slot := console.slot
+
+        if len(args) > 1 {
+            if n, err := strconv.Atoi(args[1]); err == nil && n >= 0 && n < stateSlots {
+                slot = n
+            } else {
+                fmt.Println("Invalid slot: " + args[1])
+                break
+            }
+        }
+
+        if args[0] == "s" {
+            console.saveSlot(slot)
+        } else {
+            console.loadSlot(slot)
+        }
+    case "slots":
+        console.listSlots()
+    case "r":
+        if console.inMovie() {
+            break
+        }
+
+        if !console.rewind.Enabled() {
+            fmt.Println("Rewinding is disabled")
+            break
+        }
+
+        if len(args) < 2 {
+            console.rewinding = !console.rewinding
+            if console.rewinding {
+                fmt.Printf("Rewinding, %d snapshots available\n", console.rewind.Len())
+            } else {
+                fmt.Println("Stopped rewinding")
+            }
+            break
+        }
+
+        if n, err := strconv.Atoi(args[1]); err == nil && n > 0 {
+            for i := 0; i < n; i++ {
+                if !console.rewindFrame() {
+                    break
+                }
+            }
+        } else {
+            fmt.Println("Invalid count: " + args[1])
+        }
+    case "j":
+        var buttons byte
+
+        for _, name := range args[1:] {
+            if button, ok := ParseButton(name); ok {
+                buttons |= button
+            } else {
+                fmt.Println("Unknown button: " + name)
+                return true
+            }
+        }
+
+        console.buttons = buttons
+        fmt.Println("Holding: " + ButtonsString(buttons))
+    case "cheats":
+        console.listCheats()
+    case "cheat":
+        if len(args) < 2 {
+            console.listCheats()
+            break
+        }
+
+        cheats := &console.gameBoy.Cheats
+
+        if n, err := strconv.Atoi(args[1]); err == nil {
+            if n < 1 || n > len(cheats.List()) {
+                fmt.Println("No cheat " + args[1])
+                break
+            }
+
+            cheat := cheats.List()[n-1]
+            cheats.SetEnabled(n-1, !cheat.Enabled)
+            fmt.Println(cheatString(n, cheat))
+            break
+        }
+
+        cheat, err := ParseCheat(args[1])
+        if err != nil {
+            fmt.Println("Error: " + err.Error())
+            break
+        }
+        cheat.Name = strings.Join(args[2:], " ")
+
+        cheats.Add(cheat)
+        fmt.Println(cheatString(len(cheats.List()), &cheat))
+    case "q":
+        return false
+    default:
+        fmt.Println(consoleHelp)
+    }
+
+    return true
+}
+
+func (console *console) listCheats() {
+    list := console.gameBoy.Cheats.List()
+    if len(list) == 0 {
+        fmt.Println("No cheats")
+    }
+
+    for i, cheat := range list {
+        fmt.Println(cheatString(i+1, cheat))
+    }
+}
+
+func cheatString(n int, cheat *Cheat) string {
+    state := "off"
+    if cheat.Enabled {
+        state = "on"
+    }
+
+    return strings.TrimSpace(fmt.Sprintf("%d: %-3s %-11s %s", n, state, cheat.Code, cheat.Name))
+}
+
+// Slots live next to the ROM, so game.gb saves slot 1 to game.ss1
+func (console *console) slotPath(slot int) string {
+    base := strings.TrimSuffix(console.romPath, filepath.Ext(console.romPath))
+    return fmt.Sprintf("%s.ss%d", base, slot)
+}
+
+func (console *console) saveSlot(slot int) {
+    path := console.slotPath(slot)
+
+    file, err := os.Create(path)
+    if err != nil {
+        fmt.Println("Error saving state: " + err.Error())
+        return
+    }
+    defer file.Close()
+
+    if err := console.gameBoy.SaveState(file); err != nil {
+        fmt.Println("Error saving state: " + err.Error())
+        return
+    }
+
+    console.slot = slot
+    fmt.Printf("Saved state to slot %d\n", slot)
+}
+
+func (console *console) loadSlot(slot int) {
+    file, err := os.Open(console.slotPath(slot))
+    if err != nil {
+        fmt.Println("Error loading state: " + err.Error())
+        return
+    }
+    defer file.Close()
+
+    if err := console.gameBoy.LoadState(file); err != nil {
+        fmt.Println("Error loading state: " + err.Error())
+        return
+    }
+
+    console.slot = slot
+    console.rewind.Reset()
+    fmt.Printf("Loaded state from slot %d\n", slot)
+}
+
+func (console *console) listSlots() {
+    for slot := 0; slot < stateSlots; slot++ {
+        file, err := os.Open(console.slotPath(slot))
+        if err != nil {
+            continue
+        }
+
+        header, err := ReadStateHeader(file)
+        file.Close()
+
+        if err != nil {
+            fmt.Printf("  %d: %s\n", slot, err.Error())
+        } else if header.Checksum != console.gameBoy.Checksum() {
+            fmt.Printf("  %d: %s (different ROM)\n", slot, header.Time.Format("2006-01-02 15:04:05"))
+        } else {
+            fmt.Printf("  %d: %s\n", slot, header.Time.Format("2006-01-02 15:04:05"))
+        }
+    }
+}
+
+func (console *console) recordFrame() {
+    var state bytes.Buffer
+
+    if err := console.gameBoy.SaveState(&state); err != nil {
+        fmt.Println("Error recording rewind snapshot: " + err.Error())
+        return
+    }
+
+    console.rewind.Push(state.Bytes())
+}
+
+// Restores the newest rewind snapshot, returning false once there are none
+// left
+func (console *console) rewindFrame() bool {
+    state, ok := console.rewind.Pop()
+    if !ok {
+        if console.rewinding {
+            fmt.Println("Reached the start of the rewind buffer")
+            console.rewinding = false
+        }
+        return false
+    }
+
+    if err := console.gameBoy.LoadState(bytes.NewReader(state)); err != nil {
+        fmt.Println("Error rewinding: " + err.Error())
+        console.rewinding = false
+        return false
+    }
+
+    return true
+}
+
+// Loading states mid-movie would desync it from its input
+func (console *console) inMovie() bool {
+    if console.recorder != nil || console.player != nil {
+        fmt.Println("Not available while a movie is recording or playing")
+        return true
+    }
+
+    return false
+}
+
+// Called before the first frame and at every frame boundary after that.
+// Returns false when the emulator should exit.
+func (console *console) startFrame() bool {
+    switch {
+    case console.player != nil:
+        if console.player.Frame() {
+            break
+        }
+
+        err := console.player.Verify()
+        console.player = nil
+
+        if err != nil {
+            fmt.Println("Movie verification failed: " + err.Error())
+            console.failed = true
+        } else {
+            fmt.Printf("Movie finished at frame %d, end state verified\n", console.gameBoy.Frames())
+        }
+
+        if console.verify {
+            return false
+        }
+    case console.recorder != nil:
+        console.recorder.Frame(console.buttons)
+    default:
+        console.gameBoy.Joypad.SetButtons(console.buttons)
+    }
+
+    return true
+}
+
+func (console *console) startRecording(path string, fromPowerOn bool) bool {
+    console.recorder = new(Recorder)
+
+    if err := console.recorder.Init(console.gameBoy, fromPowerOn); err != nil {
+        fmt.Println("Error starting movie recording: " + err.Error())
+        console.recorder = nil
+        return false
+    }
+
+    fmt.Println("Recording movie: " + path)
+    return true
+}
+
+func (console *console) finishRecording(path string) {
+    if console.recorder == nil {
+        return
+    }
+
+    file, err := os.Create(path)
+    if err != nil {
+        fmt.Println("Error saving movie: " + err.Error())
+        return
+    }
+    defer file.Close()
+
+    if err := console.recorder.Finish(file); err != nil {
+        fmt.Println("Error saving movie: " + err.Error())
+        return
+    }
+
+    fmt.Printf("Saved %d frame movie to %s\n", console.recorder.Frames()-1, path)
+    console.recorder = nil
+}
+
+func (console *console) startPlayback(path string) bool {
+    var movie Movie
+
+    file, err := os.Open(path)
+    if err != nil {
+        fmt.Println("Error loading movie: " + err.Error())
+        return false
+    }
+
+    err = movie.Load(file)
+    file.Close()
+
+    if err != nil {
+        fmt.Println("Error loading movie: " + err.Error())
+        return false
+    }
+
+    console.player = new(Player)
+
+    if err := console.player.Init(console.gameBoy, &movie); err != nil {
+        fmt.Println("Error loading movie: " + err.Error())
+        console.player = nil
+        return false
+    }
+
+    fmt.Printf("Playing %d frame movie: %s\n", len(movie.Inputs), path)
+    return true
+}
+
+func (console *console) exitCode() int {
+    if console.failed {
+        return 1
+    }
+
+    return 0
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+    . "./lib/gomaybe/archive"
+    . "./lib/gomaybe/disasm"
+    . "./lib/gomaybe/symbols"
+    "bufio"
+    "flag"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "os"
+)
+
+const (
+    bankSize    = 0x4000
+    headerStart = 0x0104
+    headerEnd   = 0x0150
+)
+
+// One 16KiB bank of a ROM file as seen from the CPU: bank 0 at $0000 and
+// every other bank at $4000
+type romBank struct {
+    data []byte
+    bank int
+}
+
+func (romBank romBank) Read(loc uint16) byte {
+    offset := romBank.bank*bankSize + int(loc)%bankSize
+    if offset >= len(romBank.data) {
+        return 0xFF
+    }
+
+    return romBank.data[offset]
+}
+
+// Which bank is mapped at addr while this one is at $4000
+func (romBank romBank) bankAt(addr uint16) int {
+    if addr < bankSize {
+        return 0
+    }
+
+    return romBank.bank
+}
+
+func (romBank romBank) start() uint16 {
+    if romBank.bank == 0 {
+        return 0x0000
+    }
+
+    return bankSize
+}
+
+// Prints a whole ROM as RGBDS assembly:
+//
+//     gomaybe disasm [-bank N] [-entry NAME] [-sym rom.sym] rom.gb
+func disasmMain(args []string) int {
+    flags := flag.NewFlagSet("disasm", flag.ExitOnError)
+    onlyBank := flags.Int("bank", -1, "only disassemble this bank")
+    entry := flags.String("entry", "", "file to disassemble from a zip, by default the first .gb, .gbc or .sgb file in it")
+    symPath := flags.String("sym", "", "RGBDS symbol file, by default the ROM's name with a .sym extension if there is one")
+    flags.Parse(args)
+
+    if flags.NArg() < 1 {
+        fmt.Println("Usage: gomaybe disasm [options] rom.gb")
+        flags.PrintDefaults()
+        return 1
+    }
+
+    romData, err := ioutil.ReadFile(flags.Arg(0))
+    if err == nil {
+        romData, err = Unpack(romData, *entry)
+    }
+    if err != nil {
+        fmt.Println("Error loading ROM: " + err.Error())
+        return 1
+    }
+
+    symbols, ok := loadSymbols(flags.Arg(0), *symPath)
+    if !ok {
+        return 1
+    }
+
+    out := bufio.NewWriter(os.Stdout)
+    defer out.Flush()
+
+    banks := (len(romData) + bankSize - 1) / bankSize
+
+    for bank := 0; bank < banks; bank++ {
+        if *onlyBank >= 0 && bank != *onlyBank {
+            continue
+        }
+
+        disassembleBank(out, romBank{romData, bank}, symbols)
+    }
+
+    return 0
+}
+
+func disassembleBank(out io.Writer, romBank romBank, symbols *Symbols) {
+    if romBank.bank == 0 {
+        fmt.Fprintf(out, "SECTION \"ROM Bank $000\", ROM0[$0000]\n\n")
+    } else {
+        fmt.Fprintf(out, "SECTION \"ROM Bank $%.3x\", ROMX[$4000], BANK[$%.3x]\n\n", romBank.bank, romBank.bank)
+    }
+
+    start := int(romBank.start())
+    end := start + bankSize
+
+    var namer Namer
+    if symbols != nil {
+        namer = symbols.Namer(romBank.bankAt)
+    }
+
+    for addr := start; addr < end; {
+        if namer != nil {
+            if name, ok := namer.Name(uint16(addr)); ok {
+                fmt.Fprintf(out, "%s:\n", name)
+            }
+        }
+
+        // The cartridge header is data, not code
+        if romBank.bank == 0 && addr >= headerStart && addr < headerEnd {
+            disassembleByte(out, romBank, addr)
+            addr++
+            continue
+        }
+
+        instruction := DisassembleNamed(romBank, uint16(addr), namer)
+
+        // Don't let an instruction run off the end of the bank or into the header
+        if addr+instruction.Length > end || romBank.bank == 0 && addr < headerStart && addr+instruction.Length > headerStart {
+            disassembleByte(out, romBank, addr)
+            addr++
+            continue
+        }
+
+        fmt.Fprintf(out, "    %-32s; $%.4x: %s\n", instruction.Text, addr, instruction.HexBytes())
+        addr += instruction.Length
+    }
+
+    fmt.Fprintln(out)
+}
+
+func disassembleByte(out io.Writer, romBank romBank, addr int) {
+    val := romBank.Read(uint16(addr))
+    fmt.Fprintf(out, "    %-32s; $%.4x: %.2x\n", fmt.Sprintf("db $%.2x", val), addr, val)
+}
@@ -1,47 +1,369 @@
 package main
 
 import (
-    . "./lib/gomaybe/cpu"
-    . "./lib/gomaybe/ram"
-    . "./lib/gomaybe/rom"
+    . "./lib/gomaybe/archive"
+    . "./lib/gomaybe/clock"
+    . "./lib/gomaybe/debugger"
+    . "./lib/gomaybe/gameboy"
+    . "./lib/gomaybe/gdbstub"
+    . "./lib/gomaybe/patch"
+    . "./lib/gomaybe/ppu"
+    . "./lib/gomaybe/profiler"
+    . "./lib/gomaybe/rewind"
+    . "./lib/gomaybe/symbols"
+    "bufio"
+    "flag"
     "fmt"
     "io/ioutil"
     "os"
+    "path/filepath"
+    "strings"
 )
 
 func main() {
+    if len(os.Args) > 1 && os.Args[1] == "disasm" {
+        os.Exit(disasmMain(os.Args[2:]))
+    }
+
+    os.Exit(run())
+}
+
+// Returns the process exit code. Kept apart from main so that deferred
+// cleanup runs before exiting.
+func run() int {
     var (
-        cpu Cpu
-        rom Rom
-        ram Ram
+        gameBoy GameBoy
+        clock   Clock
+        rewind  Rewind
     )
 
+    speed := flag.Float64("speed", 1, "emulation speed multiplier, 0 for unthrottled")
+    paused := flag.Bool("paused", false, "start paused")
+    slot := flag.Int("load", -1, "load the save state in this slot on start")
+    rewindMb := flag.Int("rewind-mb", 32, "memory budget for rewinding in MiB, 0 to disable")
+    rewindInterval := flag.Int("rewind-interval", 1, "frames between rewind snapshots")
+    record := flag.String("record", "", "record joypad input to this movie file, from power on unless -load is given")
+    play := flag.String("play", "", "play back a movie file")
+    verify := flag.Bool("verify", false, "play back the -play movie unthrottled, check its end state and exit")
+    trace := flag.String("trace", "", "log every instruction in Gameboy Doctor format to this file, - for stdout")
+    traceDisasm := flag.Bool("trace-disasm", false, "append the disassembled instruction to each -trace line")
+    debug := flag.Bool("debug", false, "start in the command line debugger instead of running")
+    gdb := flag.String("gdb", "", "wait for a GDB remote connection on this address, e.g. localhost:2345")
+    skipBoot := flag.Bool("skip-boot", false, "start the cartridge directly without running the boot ROM")
+    modelName := flag.String("model", "auto", "hardware to emulate: dmg, cgb, sgb, or auto to go by the cartridge header")
+    profile := flag.String("profile", "", "count the cycles spent at every address and write them to this file in pprof format on exit")
+    paletteName := flag.String("palette", "", "colours for DMG games: "+strings.Join(DmgPresets(), ", ")+", four hex colours like e0f8d0,88c070,346856,081820, or a CGB boot palette like up+a")
+    correctionName := flag.String("color-correction", "none", "how CGB colours are shown: none, or lcd to look like the CGB's screen")
+    entry := flag.String("entry", "", "file to load from a zip, by default the first .gb, .gbc or .sgb file in it")
+    patchPath := flag.String("patch", "", "IPS, UPS or BPS patch to apply, by default the ROM's name with a .ips, .ups or .bps extension if there is one")
+    cheatsPath := flag.String("cheats", "", "load the cheats listed for this ROM's checksum from this file")
+    symPath := flag.String("sym", "", "RGBDS symbol file, by default the ROM's name with a .sym extension if there is one")
+    flag.Parse()
+
     fmt.Println("GoMaybe")
     fmt.Println("Jahn Veach <j@hnvea.ch>")
     fmt.Println("https://github.com/v64/gomaybe")
 
-    if len(os.Args) < 2 {
-        fmt.Println("Usage: gomaybe rom.gb")
-        return
+    if flag.NArg() < 1 {
+        fmt.Println("Usage: gomaybe [options] rom.gb")
+        fmt.Println("       gomaybe disasm [options] rom.gb")
+        flag.PrintDefaults()
+        return 0
     }
 
-    file := os.Args[1]
+    file := flag.Arg(0)
+
+    model, err := ParseModel(*modelName)
+    if err != nil {
+        fmt.Println("Error: " + err.Error())
+        return 1
+    }
+    gameBoy.SetModel(model)
 
-    if romData, err := ioutil.ReadFile(file); err == nil {
+    if !setColors(&gameBoy, *paletteName, *correctionName) {
+        return 1
+    }
+
+    if romData, err := loadRom(file, *entry, *patchPath); err == nil {
         fmt.Println("Loading ROM: " + file)
-        ram.Init()
-        rom.Init(romData, &ram)
-        cpu.Init(&ram)
+        gameBoy.Init(romData)
+        fmt.Println("Title: " + gameBoy.Rom.Title())
+        fmt.Println("Cartridge: " + gameBoy.Rom.CartridgeName())
+        fmt.Println("Model: " + strings.ToUpper(gameBoy.Model().String()))
+        if !gameBoy.Rom.Supported() {
+            fmt.Printf("Don't know how to handle cartridge type %.2X\n", gameBoy.Rom.CartridgeType())
+        }
+        if *skipBoot {
+            gameBoy.SkipBoot()
+        }
     } else {
         fmt.Println("Error loading ROM: " + err.Error())
-        return
+        return 1
+    }
+
+    if *cheatsPath != "" {
+        if n, err := gameBoy.Cheats.LoadFile(*cheatsPath, gameBoy.Checksum()); err == nil {
+            fmt.Printf("Loaded %d cheats\n", n)
+        } else {
+            fmt.Println("Error loading cheats: " + err.Error())
+            return 1
+        }
+    }
+
+    symbols, ok := loadSymbols(file, *symPath)
+    if !ok {
+        return 1
+    } else if symbols != nil {
+        fmt.Printf("Loaded %d symbols\n", symbols.Len())
+    }
+
+    if *trace != "" {
+        out := os.Stdout
+
+        if *trace != "-" {
+            var err error
+            if out, err = os.Create(*trace); err != nil {
+                fmt.Println("Error opening trace log: " + err.Error())
+                return 1
+            }
+            defer out.Close()
+        }
+
+        tracer := bufio.NewWriter(out)
+        defer tracer.Flush()
+
+        gameBoy.Cpu.SetTracer(tracer)
+        gameBoy.Cpu.SetTraceDisassembly(*traceDisasm)
+        if symbols != nil {
+            gameBoy.Cpu.SetTraceNamer(symbols.Namer(gameBoy.BankAt))
+        }
+    }
+
+    if *debug {
+        var debugger Debugger
+        debugger.Init(&gameBoy, os.Stdout)
+        if symbols != nil {
+            debugger.SetSymbols(symbols)
+        }
+        debugger.Run(os.Stdin)
+        return 0
     }
 
+    if *gdb != "" {
+        var stub Stub
+        stub.Init(&gameBoy)
+        if err := stub.ListenAndServe(*gdb); err != nil {
+            fmt.Println("GDB stub error: " + err.Error())
+            return 1
+        }
+        return 0
+    }
+
+    step := gameBoy.Step
+
+    if *profile != "" {
+        var profiler Profiler
+        profiler.Init(&gameBoy)
+        if symbols != nil {
+            profiler.SetSymbols(symbols)
+        }
+        step = profiler.Step
+
+        defer func() {
+            if err := profiler.WriteFile(*profile); err != nil {
+                fmt.Println("Error writing profile: " + err.Error())
+            }
+        }()
+    }
+
+    clock.Init()
+    clock.SetSpeed(*speed)
+    if *paused {
+        clock.Pause()
+    }
+
+    rewind.Init(*rewindMb<<20, *rewindInterval)
+
+    console := console{gameBoy: &gameBoy, clock: &clock, rewind: &rewind, romPath: file, verify: *verify}
+
+    if *slot >= 0 {
+        console.loadSlot(*slot)
+    }
+
+    switch {
+    case *play != "":
+        if !console.startPlayback(*play) {
+            return 1
+        }
+
+        if *verify {
+            clock.SetSpeed(0)
+            clock.Resume()
+        }
+    case *verify:
+        fmt.Println("-verify needs a movie to -play")
+        return 1
+    case *record != "":
+        if !console.startRecording(*record, *slot < 0) {
+            return 1
+        }
+    }
+
+    if !console.startFrame() {
+        return console.exitCode()
+    }
+
+    commands := readCommands(os.Stdin)
+
     for {
-        cycleCount := cpu.Step()
+        if !clock.Running() && commands == nil {
+            fmt.Println("Paused with no console attached, exiting")
+            break
+        }
+
+        line, open := "", true
+
+        if clock.Running() {
+            select {
+            case line, open = <-commands:
+            default:
+            }
+        } else {
+            line, open = <-commands
+        }
+
+        if !open {
+            commands = nil
+        } else if line != "" && !console.run(line) {
+            break
+        }
+
+        if !clock.Running() {
+            continue
+        }
+
+        if console.rewinding {
+            console.rewindFrame()
+            clock.Tick(CyclesPerFrame)
+            continue
+        }
+
+        frame := gameBoy.Frames()
+
+        cycleCount := step()
         if cycleCount == -1 {
             fmt.Println("Unknown opcode encountered, exiting")
+            console.failed = true
             break
         }
+
+        clock.Tick(cycleCount)
+
+        if gameBoy.Frames() != frame {
+            if !console.startFrame() {
+                break
+            }
+
+            if rewind.Due() {
+                console.recordFrame()
+            }
+        }
+    }
+
+    if *record != "" {
+        console.finishRecording(*record)
+    }
+
+    return console.exitCode()
+}
+
+// Applies the -palette and -color-correction flags
+func setColors(gameBoy *GameBoy, paletteName string, correctionName string) bool {
+    correction, err := ParseCorrection(correctionName)
+    if err != nil {
+        fmt.Println("Error: " + err.Error())
+        return false
+    }
+    gameBoy.SetCorrection(correction)
+
+    if paletteName == "" {
+        return true
+    }
+
+    if palettes, err := ParseCompatibilityPalettes(paletteName); err == nil {
+        gameBoy.SetCompatibilityPalettes(palettes)
+    } else if palette, err := ParseDmgPalette(paletteName); err == nil {
+        gameBoy.SetDmgPalette(palette)
+    } else {
+        fmt.Println("Error: " + err.Error())
+        return false
     }
+
+    return true
+}
+
+// Reads the ROM at romPath, unpacking it if it's a zip or gzip file, and
+// applies the patch at patchPath to it, or if that's empty the first patch
+// next to the ROM with the same name. The file on disk is left alone.
+func loadRom(romPath string, entry string, patchPath string) ([]byte, error) {
+    romData, err := ioutil.ReadFile(romPath)
+    if err != nil {
+        return nil, err
+    }
+
+    if romData, err = Unpack(romData, entry); err != nil {
+        return nil, fmt.Errorf("%s: %s", romPath, err.Error())
+    }
+
+    if patchPath == "" {
+        // game.gb.gz is patched by game.ips too
+        base := strings.TrimSuffix(romPath, ".gz")
+        base = strings.TrimSuffix(base, filepath.Ext(base))
+        for _, ext := range Extensions {
+            if _, err := os.Stat(base + ext); err == nil {
+                patchPath = base + ext
+                break
+            }
+        }
+    }
+
+    if patchPath == "" {
+        return romData, nil
+    }
+
+    patchData, err := ioutil.ReadFile(patchPath)
+    if err != nil {
+        return nil, err
+    }
+
+    if romData, err = Apply(romData, patchData); err != nil {
+        return nil, fmt.Errorf("%s: %s", patchPath, err.Error())
+    }
+
+    fmt.Println("Applied patch: " + patchPath)
+    return romData, nil
+}
+
+// Loads the symbol file at symPath, or if that's empty the one next to the
+// ROM with the same name. Returns nil without an error when there's nothing
+// to load.
+func loadSymbols(romPath string, symPath string) (*Symbols, bool) {
+    explicit := symPath != ""
+    if !explicit {
+        symPath = strings.TrimSuffix(romPath, filepath.Ext(romPath)) + ".sym"
+    }
+
+    var symbols Symbols
+    symbols.Init()
+
+    if err := symbols.LoadFile(symPath); err != nil {
+        if !explicit && os.IsNotExist(err) {
+            return nil, true
+        }
+
+        fmt.Println("Error loading symbols: " + err.Error())
+        return nil, false
+    }
+
+    return &symbols, true
 }
@@ -0,0 +1,122 @@
+package archive
+
+import (
+    . "../rom"
+    "archive/zip"
+    "bytes"
+    "compress/gzip"
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "path"
+    "strings"
+)
+
+var (
+    zipMagic  = []byte("PK\x03\x04")
+    gzipMagic = []byte{0x1F, 0x8B}
+)
+
+// Extensions of the files in a zip that are taken to be ROMs
+var romExtensions = []string{".gb", ".gbc", ".sgb"}
+
+// Returns the ROM inside data if it's a zip or gzip file, or data itself if
+// it's neither. In a zip, entry picks which file to use by name, otherwise
+// it's the first one with a ROM's extension.
+func Unpack(data []byte, entry string) ([]byte, error) {
+    switch {
+    case bytes.HasPrefix(data, zipMagic):
+        return unpackZip(data, entry)
+    case bytes.HasPrefix(data, gzipMagic):
+        if entry != "" {
+            return nil, errors.New("gzip files only hold one ROM, there's no entry to choose")
+        }
+        return unpackGzip(data)
+    }
+
+    if entry != "" {
+        return nil, errors.New("not a zip file, there's no entry to choose")
+    }
+
+    return data, nil
+}
+
+func unpackGzip(data []byte) ([]byte, error) {
+    reader, err := gzip.NewReader(bytes.NewReader(data))
+    if err != nil {
+        return nil, err
+    }
+    defer reader.Close()
+
+    return readAll(reader)
+}
+
+func unpackZip(data []byte, entry string) ([]byte, error) {
+    archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+    if err != nil {
+        return nil, err
+    }
+
+    var rom *zip.File
+    for _, file := range archive.File {
+        if entry != "" && file.Name == entry || entry == "" && isRom(file.Name) {
+            rom = file
+            break
+        }
+    }
+
+    switch {
+    case rom == nil && entry != "":
+        return nil, fmt.Errorf("zip file has no %s, choose one of: %s", entry, contents(archive.File))
+    case rom == nil:
+        return nil, fmt.Errorf("zip file has no ROMs, choose one of: %s", contents(archive.File))
+    }
+
+    reader, err := rom.Open()
+    if err != nil {
+        return nil, err
+    }
+    defer reader.Close()
+
+    return readAll(reader)
+}
+
+func isRom(name string) bool {
+    ext := strings.ToLower(path.Ext(name))
+    for _, romExt := range romExtensions {
+        if ext == romExt {
+            return true
+        }
+    }
+
+    return false
+}
+
+func contents(files []*zip.File) string {
+    names := make([]string, 0, len(files))
+    for _, file := range files {
+        if !file.FileInfo().IsDir() {
+            names = append(names, file.Name)
+        }
+    }
+
+    if len(names) == 0 {
+        return "nothing"
+    }
+
+    return strings.Join(names, ", ")
+}
+
+func readAll(reader io.Reader) ([]byte, error) {
+    data, err := ioutil.ReadAll(io.LimitReader(reader, MaxSize+1))
+    if err != nil {
+        return nil, err
+    }
+
+    if len(data) > MaxSize {
+        return nil, errors.New("ROM in the archive is too big")
+    }
+
+    return data, nil
+}
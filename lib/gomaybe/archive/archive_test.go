@@ -0,0 +1,78 @@
+package archive
+
+import (
+    "archive/zip"
+    "bytes"
+    "compress/gzip"
+    "strings"
+    "testing"
+)
+
+// Files are given as names followed by contents, in the order they go in
+func makeZip(t *testing.T, files ...string) []byte {
+    var buf bytes.Buffer
+    writer := zip.NewWriter(&buf)
+
+    for i := 0; i < len(files); i += 2 {
+        file, err := writer.Create(files[i])
+        if err != nil {
+            t.Fatal(err)
+        }
+        file.Write([]byte(files[i+1]))
+    }
+
+    if err := writer.Close(); err != nil {
+        t.Fatal(err)
+    }
+
+    return buf.Bytes()
+}
+
+func checkUnpack(t *testing.T, data []byte, entry string, want string) {
+    got, err := Unpack(data, entry)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if string(got) != want {
+        t.Errorf("got %q, want %q", got, want)
+    }
+}
+
+func TestUnpack(t *testing.T) {
+    checkUnpack(t, []byte("rom"), "", "rom")
+
+    var buf bytes.Buffer
+    writer := gzip.NewWriter(&buf)
+    writer.Write([]byte("gzipped"))
+    writer.Close()
+    checkUnpack(t, buf.Bytes(), "", "gzipped")
+
+    zipped := makeZip(t, "readme.txt", "hi", "Game.GBC", "zipped")
+    checkUnpack(t, zipped, "", "zipped")
+    checkUnpack(t, zipped, "readme.txt", "hi")
+
+    if _, err := Unpack(zipped, "other.gb"); err == nil || !strings.Contains(err.Error(), "readme.txt") {
+        t.Errorf("missing entry gave %v", err)
+    }
+}
+
+func TestUnpackFirstRom(t *testing.T) {
+    zipped := makeZip(t, "readme.txt", "hi", "b.gb", "b", "a.gb", "a")
+    checkUnpack(t, zipped, "", "b")
+    checkUnpack(t, zipped, "a.gb", "a")
+
+    _, err := Unpack(makeZip(t, "readme.txt", "hi", "save.sav", ""), "")
+    if err == nil || !strings.Contains(err.Error(), "readme.txt, save.sav") {
+        t.Errorf("zip without ROMs gave %v", err)
+    }
+}
+
+func FuzzUnpack(f *testing.F) {
+    f.Add([]byte("PK\x03\x04"), "")
+    f.Add([]byte{0x1F, 0x8B, 0x08, 0x00}, "")
+
+    f.Fuzz(func(t *testing.T, data []byte, entry string) {
+        Unpack(data, entry)
+    })
+}
@@ -0,0 +1,275 @@
+package cheats
+
+import (
+    . "../ram"
+    "bufio"
+    "errors"
+    "fmt"
+    "os"
+    "strconv"
+    "strings"
+)
+
+const (
+    wramBankStart = 0xD000
+    wramBankEnd   = 0xE000
+)
+
+type Kind int
+
+const (
+    // Patches a byte of ROM as it's read, optionally only while the byte
+    // there still has its original value
+    GameGenie Kind = iota
+    // Writes a byte to RAM at the start of every VBlank
+    GameShark
+)
+
+func (kind Kind) String() string {
+    if kind == GameGenie {
+        return "Game Genie"
+    }
+
+    return "GameShark"
+}
+
+type Cheat struct {
+    Code    string
+    Name    string
+    Enabled bool
+    Kind    Kind
+    Addr    uint16
+    Val     byte
+    // The byte a Game Genie code has to find before it patches, or -1 to
+    // patch whatever is there
+    Compare int
+    // The WRAM bank a GameShark code writes to in $D000-$DFFF, or -1 for
+    // whichever is mapped in
+    Bank    int
+}
+
+// Parses a Game Genie code, ABC-DEF or ABC-DEF-GHI, or a GameShark code,
+// TTVVAAAA. Codes start enabled.
+func ParseCheat(code string) (cheat Cheat, err error) {
+    code = strings.ToUpper(strings.TrimSpace(code))
+    cheat = Cheat{Code: code, Enabled: true, Compare: -1, Bank: -1}
+
+    if strings.Contains(code, "-") {
+        cheat.Kind = GameGenie
+        err = parseGameGenie(&cheat, strings.Replace(code, "-", "", -1))
+    } else {
+        cheat.Kind = GameShark
+        err = parseGameShark(&cheat, code)
+    }
+
+    return
+}
+
+// The nine digits are the new value in AB, the address scrambled across
+// CDEF and the compare value scrambled across GI, with H unused
+func parseGameGenie(cheat *Cheat, digits string) error {
+    if len(digits) != 6 && len(digits) != 9 {
+        return errors.New("invalid Game Genie code " + cheat.Code)
+    }
+
+    d := make([]uint16, len(digits))
+    for i, c := range digits {
+        val, err := strconv.ParseUint(string(c), 16, 4)
+        if err != nil {
+            return errors.New("invalid Game Genie code " + cheat.Code)
+        }
+        d[i] = uint16(val)
+    }
+
+    cheat.Val = byte(d[0]<<4 | d[1])
+    cheat.Addr = (d[5]^0xF)<<12 | d[2]<<8 | d[3]<<4 | d[4]
+
+    if cheat.Addr >= 0x8000 {
+        return errors.New("Game Genie code " + cheat.Code + " is outside ROM")
+    }
+
+    if len(d) == 9 {
+        compare := byte(d[6]<<4 | d[8])
+        compare = compare>>2 | compare<<6
+        cheat.Compare = int(compare ^ 0xBA)
+    }
+
+    return nil
+}
+
+// TT is 01 for a plain write, or 8X or 9X to write to WRAM bank X. The
+// address is little endian.
+func parseGameShark(cheat *Cheat, digits string) error {
+    if len(digits) != 8 {
+        return errors.New("invalid cheat code " + cheat.Code)
+    }
+
+    raw, err := strconv.ParseUint(digits, 16, 32)
+    if err != nil {
+        return errors.New("invalid GameShark code " + cheat.Code)
+    }
+
+    kind := byte(raw >> 24)
+    cheat.Val = byte(raw >> 16)
+    cheat.Addr = uint16(raw>>8&0xFF) | uint16(raw&0xFF)<<8
+
+    switch kind & 0xF0 {
+    case 0x00:
+    case 0x80, 0x90:
+        cheat.Bank = int(kind & 0x07)
+    default:
+        return fmt.Errorf("unknown GameShark code type %.2X in %s", kind, cheat.Code)
+    }
+
+    return nil
+}
+
+type Cheats struct {
+    ram   *Ram
+    list  []*Cheat
+    // Enabled Game Genie codes by address, so reads only look up one map
+    genie map[uint16][]*Cheat
+}
+
+// Starts with no cheats and puts them between ram and whatever reads from
+// it. They stay attached when ram is reset.
+func (cheats *Cheats) Init(ram *Ram) {
+    cheats.ram = ram
+    cheats.list = nil
+    cheats.genie = nil
+
+    ram.SetReadFilter(cheats.filter)
+}
+
+func (cheats *Cheats) List() []*Cheat {
+    return cheats.list
+}
+
+func (cheats *Cheats) Add(cheat Cheat) {
+    cheats.list = append(cheats.list, &cheat)
+    cheats.update()
+}
+
+// Turns the cheat at index i, as returned by List, on or off
+func (cheats *Cheats) SetEnabled(i int, enabled bool) bool {
+    if i < 0 || i >= len(cheats.list) {
+        return false
+    }
+
+    cheats.list[i].Enabled = enabled
+    cheats.update()
+    return true
+}
+
+func (cheats *Cheats) Remove(i int) bool {
+    if i < 0 || i >= len(cheats.list) {
+        return false
+    }
+
+    cheats.list = append(cheats.list[:i], cheats.list[i+1:]...)
+    cheats.update()
+    return true
+}
+
+func (cheats *Cheats) update() {
+    cheats.genie = make(map[uint16][]*Cheat)
+
+    for _, cheat := range cheats.list {
+        if cheat.Enabled && cheat.Kind == GameGenie {
+            cheats.genie[cheat.Addr] = append(cheats.genie[cheat.Addr], cheat)
+        }
+    }
+}
+
+func (cheats *Cheats) filter(loc uint16, val byte) byte {
+    if loc >= 0x8000 || len(cheats.genie) == 0 {
+        return val
+    }
+
+    for _, cheat := range cheats.genie[loc] {
+        if cheat.Compare < 0 || int(val) == cheat.Compare {
+            return cheat.Val
+        }
+    }
+
+    return val
+}
+
+// Applies the GameShark codes. Called at the start of every VBlank, which
+// only happens while the LCD is on.
+func (cheats *Cheats) VBlank() {
+    for _, cheat := range cheats.list {
+        if !cheat.Enabled || cheat.Kind != GameShark {
+            continue
+        }
+
+        if cheat.Bank >= 0 && cheat.Addr >= wramBankStart && cheat.Addr < wramBankEnd {
+            // Like SVBK, bank 0 means bank 1 at $D000
+            bank := cheat.Bank
+            if bank == 0 {
+                bank = 1
+            }
+            cheats.ram.Wram(bank)[cheat.Addr-wramBankStart] = cheat.Val
+        } else {
+            cheats.ram.Write(cheat.Addr, cheat.Val)
+        }
+    }
+}
+
+// Adds the cheats listed for the ROM with the given checksum in a file like
+//
+//     # Comments start with a hash
+//     [1A2B3C4D]
+//     01FF38CD Infinite lives
+//     -00A-17B-C49 Starts disabled
+//
+// where each section is headed by the CRC-32 of a ROM. Returns how many
+// were added.
+func (cheats *Cheats) LoadFile(path string, checksum uint32) (int, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return 0, err
+    }
+    defer file.Close()
+
+    added := 0
+    ours := false
+    scanner := bufio.NewScanner(file)
+
+    for lineNum := 1; scanner.Scan(); lineNum++ {
+        line := strings.TrimSpace(scanner.Text())
+
+        switch {
+        case line == "" || strings.HasPrefix(line, "#"):
+            continue
+        case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+            sum, err := strconv.ParseUint(line[1:len(line)-1], 16, 32)
+            if err != nil {
+                return added, fmt.Errorf("%s:%d: invalid checksum %s", path, lineNum, line)
+            }
+            ours = uint32(sum) == checksum
+            continue
+        case !ours:
+            continue
+        }
+
+        enabled := !strings.HasPrefix(line, "-")
+        line = strings.TrimPrefix(line, "-")
+
+        fields := strings.SplitN(line, " ", 2)
+        cheat, err := ParseCheat(fields[0])
+        if err != nil {
+            return added, fmt.Errorf("%s:%d: %s", path, lineNum, err.Error())
+        }
+
+        if len(fields) > 1 {
+            cheat.Name = strings.TrimSpace(fields[1])
+        }
+        cheat.Enabled = enabled
+
+        cheats.Add(cheat)
+        added++
+    }
+
+    return added, scanner.Err()
+}
@@ -0,0 +1,126 @@
+package clock
+
+import (
+    "time"
+)
+
+const (
+    CyclesPerSecond = 4194304
+    CyclesPerFrame  = 70224
+)
+
+// Roughly 59.7275 Hz
+const FrameRate = float64(CyclesPerSecond) / float64(CyclesPerFrame)
+
+// How far behind real time we let the emulator fall before giving up on
+// catching up, e.g. after the process was suspended
+const maxLag = 4
+
+const frameDuration = time.Second * CyclesPerFrame / CyclesPerSecond
+
+type Clock struct {
+    speed    float64
+    paused   bool
+    advance  int
+    cycles   int
+    frames   uint64
+    deadline time.Time
+}
+
+func (clock *Clock) Init() {
+    clock.speed = 1
+    clock.paused = false
+    clock.advance = 0
+    clock.cycles = 0
+    clock.frames = 0
+    clock.deadline = time.Now()
+}
+
+// A speed of 1 is real time, above 1 fast-forwards and below 1 is slow
+// motion. A speed of 0 runs unthrottled.
+func (clock *Clock) SetSpeed(speed float64) {
+    if speed < 0 {
+        speed = 0
+    }
+
+    clock.speed = speed
+    clock.deadline = time.Now()
+}
+
+func (clock *Clock) Speed() float64 {
+    return clock.speed
+}
+
+func (clock *Clock) Pause() {
+    clock.paused = true
+    clock.advance = 0
+}
+
+func (clock *Clock) Resume() {
+    clock.paused = false
+    clock.advance = 0
+    clock.deadline = time.Now()
+}
+
+func (clock *Clock) TogglePause() {
+    if clock.paused {
+        clock.Resume()
+    } else {
+        clock.Pause()
+    }
+}
+
+func (clock *Clock) Paused() bool {
+    return clock.paused
+}
+
+// Lets one more frame run while paused
+func (clock *Clock) AdvanceFrame() {
+    if clock.paused {
+        clock.advance++
+        clock.deadline = time.Now()
+    }
+}
+
+// Whether the CPU should be stepped right now
+func (clock *Clock) Running() bool {
+    return !clock.paused || clock.advance > 0
+}
+
+func (clock *Clock) Frames() uint64 {
+    return clock.frames
+}
+
+// Accounts for cycles spent by the CPU. At the end of each frame this waits
+// until the frame is due and returns true.
+func (clock *Clock) Tick(cycles int) bool {
+    clock.cycles += cycles
+    if clock.cycles < CyclesPerFrame {
+        return false
+    }
+
+    clock.cycles -= CyclesPerFrame
+    clock.frames++
+
+    if clock.advance > 0 {
+        clock.advance--
+    }
+
+    clock.throttle()
+    return true
+}
+
+func (clock *Clock) throttle() {
+    if clock.speed == 0 {
+        return
+    }
+
+    clock.deadline = clock.deadline.Add(time.Duration(float64(frameDuration) / clock.speed))
+
+    now := time.Now()
+    if wait := clock.deadline.Sub(now); wait > 0 {
+        time.Sleep(wait)
+    } else if -wait > maxLag*frameDuration {
+        clock.deadline = now
+    }
+}
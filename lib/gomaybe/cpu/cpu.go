@@ -1,15 +1,31 @@
 package cpu
 
 import (
-    . "../ram"
+    "../disasm"
     "../util"
     "fmt"
+    "io"
 )
 
+// Everything the CPU can address. Normally this is Ram, which maps the
+// cartridge and hardware registers in.
+type Bus interface {
+    Read(loc uint16) byte
+    Write(loc uint16, val byte)
+    // Reads without side effects, for looking at memory outside of
+    // instructions
+    Peek(loc uint16) byte
+}
+
 type Cpu struct {
     aReg, bReg, cReg, dReg, eReg, fReg, hReg, lReg uint8
     spReg, pcReg                                   uint16
-    ram                                            *Ram
+    bus                                            Bus
+    tracer                                         io.Writer
+    traceDisasm                                    bool
+    traceNamer                                     disasm.Namer
+    breakpoint                                     func()
+    stop                                           func()
 }
 
 const (
@@ -22,44 +38,54 @@ const (
 var (
     opCodeTable = map[byte]func(cpu *Cpu) int{
         0x00: func(cpu *Cpu) int { return 4 },
-        0x01: func(cpu *Cpu) int { cpu.bReg, cpu.cReg = cpu.ram.ReadWordSplit(cpu.pcReg); cpu.pcReg += 2; return 12 },
-        0x02: func(cpu *Cpu) int { cpu.ram.Write(cpu.bcReg(), cpu.aReg); return 8 },
+        0x01: func(cpu *Cpu) int { cpu.bReg, cpu.cReg = cpu.readWordSplit(cpu.pcReg); cpu.pcReg += 2; return 12 },
+        0x02: func(cpu *Cpu) int { cpu.bus.Write(cpu.bcReg(), cpu.aReg); return 8 },
         0x04: func(cpu *Cpu) int { cpu.incReg(&cpu.bReg); return 4 },
-        0x06: func(cpu *Cpu) int { cpu.bReg = cpu.ram.Read(cpu.pcReg); cpu.pcReg++; return 8 },
-        0x0A: func(cpu *Cpu) int { cpu.aReg = cpu.ram.Read(cpu.bcReg()); return 8 },
+        0x06: func(cpu *Cpu) int { cpu.bReg = cpu.bus.Read(cpu.pcReg); cpu.pcReg++; return 8 },
+        0x0A: func(cpu *Cpu) int { cpu.aReg = cpu.bus.Read(cpu.bcReg()); return 8 },
         0x0C: func(cpu *Cpu) int { cpu.incReg(&cpu.cReg); return 4 },
-        0x0E: func(cpu *Cpu) int { cpu.cReg = cpu.ram.Read(cpu.pcReg); cpu.pcReg++; return 8 },
-        0x11: func(cpu *Cpu) int { cpu.dReg, cpu.eReg = cpu.ram.ReadWordSplit(cpu.pcReg); cpu.pcReg += 2; return 12 },
-        0x12: func(cpu *Cpu) int { cpu.ram.Write(cpu.deReg(), cpu.aReg); return 8 },
+        0x0E: func(cpu *Cpu) int { cpu.cReg = cpu.bus.Read(cpu.pcReg); cpu.pcReg++; return 8 },
+        0x10: func(cpu *Cpu) int {
+            // STOP is followed by a byte that's skipped over. Stop mode
+            // itself isn't emulated, so apart from the handler it does
+            // nothing.
+            cpu.pcReg++
+            if cpu.stop != nil {
+                cpu.stop()
+            }
+            return 4
+        },
+        0x11: func(cpu *Cpu) int { cpu.dReg, cpu.eReg = cpu.readWordSplit(cpu.pcReg); cpu.pcReg += 2; return 12 },
+        0x12: func(cpu *Cpu) int { cpu.bus.Write(cpu.deReg(), cpu.aReg); return 8 },
         0x14: func(cpu *Cpu) int { cpu.incReg(&cpu.dReg); return 4 },
-        0x16: func(cpu *Cpu) int { cpu.dReg = cpu.ram.Read(cpu.pcReg); cpu.pcReg++; return 8 },
+        0x16: func(cpu *Cpu) int { cpu.dReg = cpu.bus.Read(cpu.pcReg); cpu.pcReg++; return 8 },
         0x17: func(cpu *Cpu) int { cpu.rotateLeft(&cpu.aReg); return 4 },
-        0x1A: func(cpu *Cpu) int { cpu.aReg = cpu.ram.Read(cpu.deReg()); return 8 },
+        0x1A: func(cpu *Cpu) int { cpu.aReg = cpu.bus.Read(cpu.deReg()); return 8 },
         0x1C: func(cpu *Cpu) int { cpu.incReg(&cpu.eReg); return 4 },
-        0x1E: func(cpu *Cpu) int { cpu.eReg = cpu.ram.Read(cpu.pcReg); cpu.pcReg++; return 8 },
+        0x1E: func(cpu *Cpu) int { cpu.eReg = cpu.bus.Read(cpu.pcReg); cpu.pcReg++; return 8 },
         0x20: func(cpu *Cpu) int {
             if !cpu.getFlag(flag_Z) {
-                cpu.pcReg += uint16(int8(cpu.ram.Read(cpu.pcReg))) + 1
+                cpu.pcReg += uint16(int8(cpu.bus.Read(cpu.pcReg))) + 1
                 return 12
             }
 
             cpu.pcReg++
             return 8
         },
-        0x21: func(cpu *Cpu) int { cpu.hReg, cpu.lReg = cpu.ram.ReadWordSplit(cpu.pcReg); cpu.pcReg += 2; return 12 },
+        0x21: func(cpu *Cpu) int { cpu.hReg, cpu.lReg = cpu.readWordSplit(cpu.pcReg); cpu.pcReg += 2; return 12 },
         0x24: func(cpu *Cpu) int { cpu.incReg(&cpu.hReg); return 4 },
-        0x26: func(cpu *Cpu) int { cpu.hReg = cpu.ram.Read(cpu.pcReg); cpu.pcReg++; return 8 },
+        0x26: func(cpu *Cpu) int { cpu.hReg = cpu.bus.Read(cpu.pcReg); cpu.pcReg++; return 8 },
         0x2C: func(cpu *Cpu) int { cpu.incReg(&cpu.lReg); return 4 },
-        0x2E: func(cpu *Cpu) int { cpu.lReg = cpu.ram.Read(cpu.pcReg); cpu.pcReg++; return 8 },
+        0x2E: func(cpu *Cpu) int { cpu.lReg = cpu.bus.Read(cpu.pcReg); cpu.pcReg++; return 8 },
         0x2F: func(cpu *Cpu) int {
             cpu.aReg = ^cpu.aReg
             cpu.setFlag(flag_N, true)
             cpu.setFlag(flag_H, true)
             return 4
         },
-        0x31: func(cpu *Cpu) int { cpu.spReg = cpu.ram.ReadWord(cpu.pcReg); cpu.pcReg += 2; return 12 },
-        0x32: func(cpu *Cpu) int { cpu.ram.Write(cpu.hlReg(), cpu.aReg); cpu.setRegVal("hl", cpu.hlReg()-1); return 8 },
-        0x36: func(cpu *Cpu) int { cpu.ram.Write(cpu.hlReg(), cpu.ram.Read(cpu.pcReg)); cpu.pcReg++; return 12 },
+        0x31: func(cpu *Cpu) int { cpu.spReg = cpu.readWord(cpu.pcReg); cpu.pcReg += 2; return 12 },
+        0x32: func(cpu *Cpu) int { cpu.bus.Write(cpu.hlReg(), cpu.aReg); cpu.setRegVal("hl", cpu.hlReg()-1); return 8 },
+        0x36: func(cpu *Cpu) int { cpu.bus.Write(cpu.hlReg(), cpu.bus.Read(cpu.pcReg)); cpu.pcReg++; return 12 },
         0x37: func(cpu *Cpu) int {
             cpu.setFlag(flag_N, false)
             cpu.setFlag(flag_H, false)
@@ -67,7 +93,7 @@ var (
             return 4
         },
         0x3C: func(cpu *Cpu) int { cpu.incReg(&cpu.aReg); return 4 },
-        0x3E: func(cpu *Cpu) int { cpu.aReg = cpu.ram.Read(cpu.pcReg); cpu.pcReg++; return 8 },
+        0x3E: func(cpu *Cpu) int { cpu.aReg = cpu.bus.Read(cpu.pcReg); cpu.pcReg++; return 8 },
         0x3F: func(cpu *Cpu) int {
             cpu.setFlag(flag_N, false)
             cpu.setFlag(flag_H, false)
@@ -80,7 +106,7 @@ var (
         0x43: func(cpu *Cpu) int { cpu.bReg = cpu.eReg; return 4 },
         0x44: func(cpu *Cpu) int { cpu.bReg = cpu.hReg; return 4 },
         0x45: func(cpu *Cpu) int { cpu.bReg = cpu.lReg; return 4 },
-        0x46: func(cpu *Cpu) int { cpu.bReg = cpu.ram.Read(cpu.hlReg()); return 8 },
+        0x46: func(cpu *Cpu) int { cpu.bReg = cpu.bus.Read(cpu.hlReg()); return 8 },
         0x47: func(cpu *Cpu) int { cpu.bReg = cpu.aReg; return 4 },
         0x48: func(cpu *Cpu) int { cpu.cReg = cpu.bReg; return 4 },
         0x49: func(cpu *Cpu) int { return 4 },
@@ -88,7 +114,7 @@ var (
         0x4B: func(cpu *Cpu) int { cpu.cReg = cpu.eReg; return 4 },
         0x4C: func(cpu *Cpu) int { cpu.cReg = cpu.hReg; return 4 },
         0x4D: func(cpu *Cpu) int { cpu.cReg = cpu.lReg; return 4 },
-        0x4E: func(cpu *Cpu) int { cpu.cReg = cpu.ram.Read(cpu.hlReg()); return 8 },
+        0x4E: func(cpu *Cpu) int { cpu.cReg = cpu.bus.Read(cpu.hlReg()); return 8 },
         0x4F: func(cpu *Cpu) int { cpu.cReg = cpu.aReg; return 4 },
         0x50: func(cpu *Cpu) int { cpu.dReg = cpu.bReg; return 4 },
         0x51: func(cpu *Cpu) int { cpu.dReg = cpu.cReg; return 4 },
@@ -96,7 +122,7 @@ var (
         0x53: func(cpu *Cpu) int { cpu.dReg = cpu.eReg; return 4 },
         0x54: func(cpu *Cpu) int { cpu.dReg = cpu.hReg; return 4 },
         0x55: func(cpu *Cpu) int { cpu.dReg = cpu.lReg; return 4 },
-        0x56: func(cpu *Cpu) int { cpu.dReg = cpu.ram.Read(cpu.hlReg()); return 8 },
+        0x56: func(cpu *Cpu) int { cpu.dReg = cpu.bus.Read(cpu.hlReg()); return 8 },
         0x57: func(cpu *Cpu) int { cpu.dReg = cpu.aReg; return 4 },
         0x58: func(cpu *Cpu) int { cpu.eReg = cpu.bReg; return 4 },
         0x59: func(cpu *Cpu) int { cpu.eReg = cpu.cReg; return 4 },
@@ -104,7 +130,7 @@ var (
         0x5B: func(cpu *Cpu) int { return 4 },
         0x5C: func(cpu *Cpu) int { cpu.eReg = cpu.hReg; return 4 },
         0x5D: func(cpu *Cpu) int { cpu.eReg = cpu.lReg; return 4 },
-        0x5E: func(cpu *Cpu) int { cpu.eReg = cpu.ram.Read(cpu.hlReg()); return 8 },
+        0x5E: func(cpu *Cpu) int { cpu.eReg = cpu.bus.Read(cpu.hlReg()); return 8 },
         0x5F: func(cpu *Cpu) int { cpu.eReg = cpu.aReg; return 4 },
         0x60: func(cpu *Cpu) int { cpu.hReg = cpu.bReg; return 4 },
         0x61: func(cpu *Cpu) int { cpu.hReg = cpu.cReg; return 4 },
@@ -112,7 +138,7 @@ var (
         0x63: func(cpu *Cpu) int { cpu.hReg = cpu.eReg; return 4 },
         0x64: func(cpu *Cpu) int { return 4 },
         0x65: func(cpu *Cpu) int { cpu.hReg = cpu.lReg; return 4 },
-        0x66: func(cpu *Cpu) int { cpu.hReg = cpu.ram.Read(cpu.hlReg()); return 8 },
+        0x66: func(cpu *Cpu) int { cpu.hReg = cpu.bus.Read(cpu.hlReg()); return 8 },
         0x67: func(cpu *Cpu) int { cpu.hReg = cpu.aReg; return 4 },
         0x68: func(cpu *Cpu) int { cpu.lReg = cpu.bReg; return 4 },
         0x69: func(cpu *Cpu) int { cpu.lReg = cpu.cReg; return 4 },
@@ -120,22 +146,22 @@ var (
         0x6B: func(cpu *Cpu) int { cpu.lReg = cpu.eReg; return 4 },
         0x6C: func(cpu *Cpu) int { cpu.lReg = cpu.hReg; return 4 },
         0x6D: func(cpu *Cpu) int { return 4 },
-        0x6E: func(cpu *Cpu) int { cpu.lReg = cpu.ram.Read(cpu.hlReg()); return 8 },
+        0x6E: func(cpu *Cpu) int { cpu.lReg = cpu.bus.Read(cpu.hlReg()); return 8 },
         0x6F: func(cpu *Cpu) int { cpu.lReg = cpu.aReg; return 4 },
-        0x70: func(cpu *Cpu) int { cpu.ram.Write(cpu.hlReg(), cpu.bReg); return 8 },
-        0x71: func(cpu *Cpu) int { cpu.ram.Write(cpu.hlReg(), cpu.cReg); return 8 },
-        0x72: func(cpu *Cpu) int { cpu.ram.Write(cpu.hlReg(), cpu.dReg); return 8 },
-        0x73: func(cpu *Cpu) int { cpu.ram.Write(cpu.hlReg(), cpu.eReg); return 8 },
-        0x74: func(cpu *Cpu) int { cpu.ram.Write(cpu.hlReg(), cpu.hReg); return 8 },
-        0x75: func(cpu *Cpu) int { cpu.ram.Write(cpu.hlReg(), cpu.lReg); return 8 },
-        0x77: func(cpu *Cpu) int { cpu.ram.Write(cpu.hlReg(), cpu.aReg); return 8 },
+        0x70: func(cpu *Cpu) int { cpu.bus.Write(cpu.hlReg(), cpu.bReg); return 8 },
+        0x71: func(cpu *Cpu) int { cpu.bus.Write(cpu.hlReg(), cpu.cReg); return 8 },
+        0x72: func(cpu *Cpu) int { cpu.bus.Write(cpu.hlReg(), cpu.dReg); return 8 },
+        0x73: func(cpu *Cpu) int { cpu.bus.Write(cpu.hlReg(), cpu.eReg); return 8 },
+        0x74: func(cpu *Cpu) int { cpu.bus.Write(cpu.hlReg(), cpu.hReg); return 8 },
+        0x75: func(cpu *Cpu) int { cpu.bus.Write(cpu.hlReg(), cpu.lReg); return 8 },
+        0x77: func(cpu *Cpu) int { cpu.bus.Write(cpu.hlReg(), cpu.aReg); return 8 },
         0x78: func(cpu *Cpu) int { cpu.aReg = cpu.bReg; return 4 },
         0x79: func(cpu *Cpu) int { cpu.aReg = cpu.cReg; return 4 },
         0x7A: func(cpu *Cpu) int { cpu.aReg = cpu.dReg; return 4 },
         0x7B: func(cpu *Cpu) int { cpu.aReg = cpu.eReg; return 4 },
         0x7C: func(cpu *Cpu) int { cpu.aReg = cpu.hReg; return 4 },
         0x7D: func(cpu *Cpu) int { cpu.aReg = cpu.lReg; return 4 },
-        0x7E: func(cpu *Cpu) int { cpu.aReg = cpu.ram.Read(cpu.hlReg()); return 8 },
+        0x7E: func(cpu *Cpu) int { cpu.aReg = cpu.bus.Read(cpu.hlReg()); return 8 },
         0x7F: func(cpu *Cpu) int { return 4 },
         0xA0: func(cpu *Cpu) int { cpu.and_A(cpu.bReg); return 4 },
         0xA1: func(cpu *Cpu) int { cpu.and_A(cpu.cReg); return 4 },
@@ -143,12 +169,12 @@ var (
         0xA3: func(cpu *Cpu) int { cpu.and_A(cpu.eReg); return 4 },
         0xA4: func(cpu *Cpu) int { cpu.and_A(cpu.hReg); return 4 },
         0xA5: func(cpu *Cpu) int { cpu.and_A(cpu.lReg); return 4 },
-        0xA6: func(cpu *Cpu) int { cpu.and_A(cpu.ram.Read(cpu.hlReg())); return 8 },
+        0xA6: func(cpu *Cpu) int { cpu.and_A(cpu.bus.Read(cpu.hlReg())); return 8 },
         0xA7: func(cpu *Cpu) int { cpu.and_A(cpu.aReg); return 4 },
         0xA8: func(cpu *Cpu) int { cpu.xor_A(cpu.bReg); return 4 },
         0xA9: func(cpu *Cpu) int { cpu.xor_A(cpu.cReg); return 4 },
         0xAA: func(cpu *Cpu) int { cpu.xor_A(cpu.dReg); return 4 },
-        0xAE: func(cpu *Cpu) int { cpu.xor_A(cpu.ram.Read(cpu.hlReg())); return 8 },
+        0xAE: func(cpu *Cpu) int { cpu.xor_A(cpu.bus.Read(cpu.hlReg())); return 8 },
         0xAB: func(cpu *Cpu) int { cpu.xor_A(cpu.eReg); return 4 },
         0xAC: func(cpu *Cpu) int { cpu.xor_A(cpu.hReg); return 4 },
         0xAD: func(cpu *Cpu) int { cpu.xor_A(cpu.lReg); return 4 },
@@ -159,29 +185,29 @@ var (
         0xB3: func(cpu *Cpu) int { cpu.or_A(cpu.eReg); return 4 },
         0xB4: func(cpu *Cpu) int { cpu.or_A(cpu.hReg); return 4 },
         0xB5: func(cpu *Cpu) int { cpu.or_A(cpu.lReg); return 4 },
-        0xB6: func(cpu *Cpu) int { cpu.or_A(cpu.ram.Read(cpu.hlReg())); return 8 },
+        0xB6: func(cpu *Cpu) int { cpu.or_A(cpu.bus.Read(cpu.hlReg())); return 8 },
         0xB7: func(cpu *Cpu) int { cpu.or_A(cpu.aReg); return 4 },
         0xC1: func(cpu *Cpu) int { cpu.setRegVal("bc", cpu.pop()); return 12 },
-        0xC3: func(cpu *Cpu) int { cpu.pcReg = cpu.ram.ReadWord(cpu.pcReg); return 16 },
+        0xC3: func(cpu *Cpu) int { cpu.pcReg = cpu.readWord(cpu.pcReg); return 16 },
         0xC5: func(cpu *Cpu) int { cpu.push(cpu.bcReg()); return 16 },
         0xCD: func(cpu *Cpu) int { cpu.call(); return 24 },
         0xD1: func(cpu *Cpu) int { cpu.setRegVal("de", cpu.pop()); return 12 },
         0xD5: func(cpu *Cpu) int { cpu.push(cpu.deReg()); return 16 },
         0xE0: func(cpu *Cpu) int {
-            cpu.ram.Write(0xFF00+uint16(cpu.ram.Read(cpu.pcReg)), cpu.aReg)
+            cpu.bus.Write(0xFF00+uint16(cpu.bus.Read(cpu.pcReg)), cpu.aReg)
             cpu.pcReg++
             return 12
         },
         0xE1: func(cpu *Cpu) int { cpu.setRegVal("hl", cpu.pop()); return 12 },
-        0xE2: func(cpu *Cpu) int { cpu.ram.Write(0xFF00+uint16(cpu.cReg), cpu.aReg); return 8 },
+        0xE2: func(cpu *Cpu) int { cpu.bus.Write(0xFF00+uint16(cpu.cReg), cpu.aReg); return 8 },
         0xE5: func(cpu *Cpu) int { cpu.push(cpu.hlReg()); return 16 },
-        0xE6: func(cpu *Cpu) int { cpu.and_A(cpu.ram.Read(cpu.pcReg)); cpu.pcReg++; return 8 },
-        0xEA: func(cpu *Cpu) int { cpu.ram.Write(cpu.ram.ReadWord(cpu.pcReg), cpu.aReg); cpu.pcReg += 2; return 16 },
-        0xEE: func(cpu *Cpu) int { cpu.xor_A(cpu.ram.Read(cpu.pcReg)); cpu.pcReg++; return 8 },
-        0xF1: func(cpu *Cpu) int { cpu.setRegVal("af", cpu.pop()); return 12 },
-        0xFA: func(cpu *Cpu) int { cpu.aReg = cpu.ram.Read(cpu.ram.ReadWord(cpu.pcReg)); cpu.pcReg += 2; return 16 },
+        0xE6: func(cpu *Cpu) int { cpu.and_A(cpu.bus.Read(cpu.pcReg)); cpu.pcReg++; return 8 },
+        0xEA: func(cpu *Cpu) int { cpu.bus.Write(cpu.readWord(cpu.pcReg), cpu.aReg); cpu.pcReg += 2; return 16 },
+        0xEE: func(cpu *Cpu) int { cpu.xor_A(cpu.bus.Read(cpu.pcReg)); cpu.pcReg++; return 8 },
+        0xF1: func(cpu *Cpu) int { cpu.setRegVal("af", cpu.pop()&0xFFF0); return 12 },
+        0xFA: func(cpu *Cpu) int { cpu.aReg = cpu.bus.Read(cpu.readWord(cpu.pcReg)); cpu.pcReg += 2; return 16 },
         0xF5: func(cpu *Cpu) int { cpu.push(cpu.afReg()); return 16 },
-        0xF6: func(cpu *Cpu) int { cpu.or_A(cpu.ram.Read(cpu.pcReg)); cpu.pcReg++; return 8 },
+        0xF6: func(cpu *Cpu) int { cpu.or_A(cpu.bus.Read(cpu.pcReg)); cpu.pcReg++; return 8 },
         0xF9: func(cpu *Cpu) int { cpu.spReg = cpu.hlReg(); return 8 },
     }
 
@@ -202,10 +228,35 @@ var (
     }
 )
 
-func (cpu *Cpu) Init(ram *Ram) {
+func (cpu *Cpu) Init(bus Bus) {
     cpu.spReg = 0xFFFE
     cpu.pcReg = 0x0000
-    cpu.ram = ram
+    cpu.bus = bus
+}
+
+// Puts the registers in the state the DMG boot ROM leaves them in, or the
+// CGB one if cgb is set
+func (cpu *Cpu) SkipBoot(cgb bool) {
+    if cgb {
+        cpu.aReg, cpu.fReg = 0x11, 0x80
+        cpu.bReg, cpu.cReg = 0x00, 0x00
+        cpu.dReg, cpu.eReg = 0xFF, 0x56
+        cpu.hReg, cpu.lReg = 0x00, 0x0D
+    } else {
+        cpu.aReg, cpu.fReg = 0x01, 0xB0
+        cpu.bReg, cpu.cReg = 0x00, 0x13
+        cpu.dReg, cpu.eReg = 0x00, 0xD8
+        cpu.hReg, cpu.lReg = 0x01, 0x4D
+    }
+
+    cpu.spReg = 0xFFFE
+    cpu.pcReg = 0x0100
+}
+
+// Calls handler whenever STOP is executed, which is how the CGB switches
+// speed
+func (cpu *Cpu) SetStopHandler(handler func()) {
+    cpu.stop = handler
 }
 
 func (cpu *Cpu) Step() (cycles int) {
@@ -214,9 +265,14 @@ func (cpu *Cpu) Step() (cycles int) {
         ok          bool
     )
 
+    if cpu.tracer != nil {
+        cpu.trace()
+    }
+
     opCode := cpu.nextOpCode()
+    prefixed := opCode == 0xCB
 
-    if opCode == 0xCB {
+    if prefixed {
         opCode = cpu.nextOpCode()
         instruction, ok = cbOpCodeTable[opCode]
     } else {
@@ -225,7 +281,10 @@ func (cpu *Cpu) Step() (cycles int) {
 
     if ok {
         cycles = instruction(cpu)
-        fmt.Println(cpu)
+
+        if !prefixed && opCode == 0x40 && cpu.breakpoint != nil {
+            cpu.breakpoint()
+        }
     } else {
         fmt.Printf("Unknown OP: 0x%.2X\n", opCode)
         cycles = -1
@@ -235,9 +294,8 @@ func (cpu *Cpu) Step() (cycles int) {
 }
 
 func (cpu *Cpu) nextOpCode() (opCode byte) {
-    opCode = cpu.ram.Read(cpu.pcReg)
+    opCode = cpu.bus.Read(cpu.pcReg)
     cpu.pcReg++
-    fmt.Printf("OP: 0x%.2X\n", opCode)
     return
 }
 
@@ -334,19 +392,37 @@ func (cpu *Cpu) setRegVal(reg string, val uint16) {
     *highReg, *lowReg = util.W2B(val)
 }
 
+// Words are read low byte first, the same order the hardware reads them in
+func (cpu *Cpu) readWord(loc uint16) uint16 {
+    most, least := cpu.readWordSplit(loc)
+    return util.B2W(most, least)
+}
+
+func (cpu *Cpu) readWordSplit(loc uint16) (most byte, least byte) {
+    least = cpu.bus.Read(loc)
+    most = cpu.bus.Read(loc + 1)
+    return
+}
+
+// Pushes write the high byte first, as the stack pointer counts down
 func (cpu *Cpu) push(val uint16) {
-    cpu.spReg -= 2
-    cpu.ram.WriteWord(cpu.spReg, val)
+    most, least := util.W2B(val)
+    cpu.spReg--
+    cpu.bus.Write(cpu.spReg, most)
+    cpu.spReg--
+    cpu.bus.Write(cpu.spReg, least)
 }
 
 func (cpu *Cpu) pop() (val uint16) {
-    val = cpu.ram.ReadWord(cpu.spReg)
+    val = cpu.readWord(cpu.spReg)
     cpu.spReg += 2
     return
 }
 
 func (cpu *Cpu) call() {
-    loc := cpu.ram.ReadWord(cpu.pcReg)
+    loc := cpu.readWord(cpu.pcReg)
+    // The return address is after the operand
+    cpu.pcReg += 2
     cpu.push(cpu.pcReg)
     cpu.pcReg = loc
 }
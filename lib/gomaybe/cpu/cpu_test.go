@@ -0,0 +1,165 @@
+package cpu
+
+import (
+    "../disasm"
+    "bytes"
+    "encoding/binary"
+    "strings"
+    "testing"
+)
+
+// Longest run of instructions tried per input
+const fuzzSteps = 1000
+
+// The first 12 bytes of the input set the registers, including PC and SP so
+// that runs near the ends of memory get tried, and the rest is loaded at PC
+func FuzzStep(f *testing.F) {
+    f.Add([]byte{0x01, 0xB0, 0x00, 0x13, 0x00, 0xD8, 0x01, 0x4D, 0xFE, 0xFF, 0x00, 0x01, 0x00})
+    // POP AF with junk on the stack
+    f.Add([]byte{0, 0, 0, 0, 0, 0, 0, 0, 0x00, 0xC0, 0x00, 0xC1, 0xF1, 0xF1, 0xF5, 0xF1})
+    // PUSH and CALL across the top of memory
+    f.Add([]byte{0, 0, 0, 0, 0, 0, 0, 0, 0x01, 0x00, 0xFE, 0xFF, 0xC5, 0xCD, 0x00, 0x00})
+
+    f.Fuzz(func(t *testing.T, data []byte) {
+        if len(data) < 12 {
+            return
+        }
+
+        var (
+            cpu Cpu
+            bus testBus
+        )
+
+        cpu.Init(&bus)
+        cpu.aReg, cpu.fReg = data[0], data[1]&0xF0
+        cpu.bReg, cpu.cReg = data[2], data[3]
+        cpu.dReg, cpu.eReg = data[4], data[5]
+        cpu.hReg, cpu.lReg = data[6], data[7]
+        cpu.spReg = binary.LittleEndian.Uint16(data[8:])
+        cpu.pcReg = binary.LittleEndian.Uint16(data[10:])
+
+        // Wrapping around the end of memory like the address bus does
+        for i, val := range data[12:] {
+            bus.mem[cpu.pcReg+uint16(i)] = val
+        }
+
+        for i := 0; i < fuzzSteps; i++ {
+            pc := cpu.pcReg
+            before := cpu
+            instruction := disasm.Disassemble(busPeeker{&bus}, pc)
+            top := uint16(bus.mem[cpu.spReg+1])<<8 | uint16(bus.mem[cpu.spReg])
+
+            cycles := cpu.Step()
+            if cycles < 0 {
+                return
+            }
+
+            if cycles == 0 || cycles%4 != 0 {
+                t.Fatalf("instruction at $%.4x took %d cycles", pc, cycles)
+            }
+
+            if cpu.fReg&0x0F != 0 {
+                t.Fatalf("instruction at $%.4x set the low bits of F to $%.2x", pc, cpu.fReg)
+            }
+
+            checkPcSp(t, &before, &cpu, &bus, instruction, top)
+        }
+    })
+}
+
+// Opcodes grouped by what they do to PC and SP
+var (
+    pushOps   = opSet(0xC5, 0xD5, 0xE5, 0xF5)
+    popOps    = opSet(0xC1, 0xD1, 0xE1, 0xF1)
+    callOps   = opSet(0xCD, 0xC4, 0xCC, 0xD4, 0xDC)
+    rstOps    = opSet(0xC7, 0xCF, 0xD7, 0xDF, 0xE7, 0xEF, 0xF7, 0xFF)
+    retOps    = opSet(0xC9, 0xD9, 0xC0, 0xC8, 0xD0, 0xD8)
+    jumpOps   = opSet(0xC3, 0xC2, 0xCA, 0xD2, 0xDA, 0x18, 0x20, 0x28, 0x30, 0x38)
+    condOps   = opSet(0xC4, 0xCC, 0xD4, 0xDC, 0xC0, 0xC8, 0xD0, 0xD8, 0xC2, 0xCA, 0xD2, 0xDA, 0x20, 0x28, 0x30, 0x38)
+    loadSpOps = opSet(0x31, 0x33, 0x3B, 0xE8, 0xF9)
+)
+
+func opSet(opCodes ...byte) map[byte]bool {
+    set := make(map[byte]bool)
+    for _, opCode := range opCodes {
+        set[opCode] = true
+    }
+    return set
+}
+
+// Checks that PC and SP moved the way the instruction says they should,
+// wrapping around the ends of memory. top is the word that was on top of
+// the stack beforehand.
+func checkPcSp(t *testing.T, before *Cpu, after *Cpu, bus *testBus, instruction disasm.Instruction, top uint16) {
+    pc, sp := before.pcReg, before.spReg
+    next := pc + uint16(instruction.Length)
+    opCode := instruction.Bytes[0]
+
+    // Conditional branches that weren't taken just carry on
+    if condOps[opCode] && after.pcReg == next && after.spReg == sp {
+        return
+    }
+
+    wantPc, wantSp := next, sp
+    var pushed uint16
+    pushes := false
+
+    switch {
+    case pushOps[opCode]:
+        wantSp, pushes = sp-2, true
+        pushed = map[byte]uint16{
+            0xC5: before.bcReg(), 0xD5: before.deReg(), 0xE5: before.hlReg(),
+            0xF5: uint16(before.aReg)<<8 | uint16(before.fReg),
+        }[opCode]
+    case popOps[opCode]:
+        wantSp = sp + 2
+    case callOps[opCode], rstOps[opCode]:
+        wantPc, wantSp, pushed, pushes = instruction.Target, sp-2, next, true
+    case retOps[opCode]:
+        wantPc, wantSp = top, sp+2
+    case jumpOps[opCode]:
+        wantPc = instruction.Target
+    case opCode == 0xE9:
+        wantPc = before.hlReg()
+    case loadSpOps[opCode]:
+        wantSp = after.spReg
+    }
+
+    if after.pcReg != wantPc {
+        t.Fatalf("%s at $%.4x left PC at $%.4x, expected $%.4x", instruction.Text, pc, after.pcReg, wantPc)
+    }
+
+    if after.spReg != wantSp {
+        t.Fatalf("%s at $%.4x left SP at $%.4x, expected $%.4x", instruction.Text, pc, after.spReg, wantSp)
+    }
+
+    if pushes {
+        if got := uint16(bus.mem[sp-1])<<8 | uint16(bus.mem[sp-2]); got != pushed {
+            t.Fatalf("%s at $%.4x pushed $%.4x to $%.4x, expected $%.4x", instruction.Text, pc, got, sp-2, pushed)
+        }
+    }
+}
+
+func TestTraceWithoutReads(t *testing.T) {
+    var (
+        cpu   Cpu
+        bus   testBus
+        trace bytes.Buffer
+    )
+
+    cpu.Init(&bus)
+    cpu.SetTracer(&trace)
+    cpu.SetTraceDisassembly(true)
+
+    // LD A, $42
+    bus.mem[0], bus.mem[1] = 0x3E, 0x42
+    cpu.Step()
+
+    if len(bus.accesses) != 2 {
+        t.Errorf("tracing one instruction made accesses %v", bus.accesses)
+    }
+
+    if !strings.Contains(trace.String(), "PCMEM:3E,42,00,00") {
+        t.Errorf("trace line %q", trace.String())
+    }
+}
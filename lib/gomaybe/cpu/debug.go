@@ -0,0 +1,99 @@
+package cpu
+
+import (
+    "../util"
+    "strings"
+)
+
+// Names accepted by Register and SetRegister
+var RegisterNames = []string{"a", "f", "b", "c", "d", "e", "h", "l", "af", "bc", "de", "hl", "sp", "pc"}
+
+func (cpu *Cpu) PC() uint16 {
+    return cpu.pcReg
+}
+
+func (cpu *Cpu) SP() uint16 {
+    return cpu.spReg
+}
+
+// Calls handler after every LD B,B, which test ROMs and homebrew use as a
+// software breakpoint since it does nothing. Pass nil to remove it.
+func (cpu *Cpu) SetBreakpointHandler(handler func()) {
+    cpu.breakpoint = handler
+}
+
+func (cpu *Cpu) Register(name string) (val uint16, ok bool) {
+    ok = true
+
+    switch strings.ToLower(name) {
+    case "a":
+        val = uint16(cpu.aReg)
+    case "f":
+        val = uint16(cpu.fReg)
+    case "b":
+        val = uint16(cpu.bReg)
+    case "c":
+        val = uint16(cpu.cReg)
+    case "d":
+        val = uint16(cpu.dReg)
+    case "e":
+        val = uint16(cpu.eReg)
+    case "h":
+        val = uint16(cpu.hReg)
+    case "l":
+        val = uint16(cpu.lReg)
+    case "af":
+        val = cpu.afReg()
+    case "bc":
+        val = cpu.bcReg()
+    case "de":
+        val = cpu.deReg()
+    case "hl":
+        val = cpu.hlReg()
+    case "sp":
+        val = cpu.spReg
+    case "pc":
+        val = cpu.pcReg
+    default:
+        ok = false
+    }
+
+    return
+}
+
+// Single registers take the low byte of val. The low nibble of F always
+// reads as zero on hardware so it's dropped.
+func (cpu *Cpu) SetRegister(name string, val uint16) bool {
+    _, low := util.W2B(val)
+
+    switch strings.ToLower(name) {
+    case "a":
+        cpu.aReg = low
+    case "f":
+        cpu.fReg = low & 0xF0
+    case "b":
+        cpu.bReg = low
+    case "c":
+        cpu.cReg = low
+    case "d":
+        cpu.dReg = low
+    case "e":
+        cpu.eReg = low
+    case "h":
+        cpu.hReg = low
+    case "l":
+        cpu.lReg = low
+    case "af":
+        cpu.setRegVal("af", val&0xFFF0)
+    case "bc", "de", "hl":
+        cpu.setRegVal(strings.ToLower(name), val)
+    case "sp":
+        cpu.spReg = val
+    case "pc":
+        cpu.pcReg = val
+    default:
+        return false
+    }
+
+    return true
+}
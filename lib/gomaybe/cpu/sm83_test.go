@@ -0,0 +1,255 @@
+package cpu
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "testing"
+)
+
+// The per-opcode JSON tests from the SingleStepTests sm83 suite aren't
+// distributed with the emulator. Copy the v1 directory into testdata/sm83,
+// so that there's a testdata/sm83/00.json, testdata/sm83/cb 00.json and so
+// on. Each implemented opcode without a file is skipped.
+const sm83Dir = "testdata/sm83"
+
+// How many failing cases to describe for each opcode before just counting
+// them
+const sm83MaxReports = 5
+
+type sm83State struct {
+    PC  uint16   `json:"pc"`
+    SP  uint16   `json:"sp"`
+    A   byte     `json:"a"`
+    B   byte     `json:"b"`
+    C   byte     `json:"c"`
+    D   byte     `json:"d"`
+    E   byte     `json:"e"`
+    F   byte     `json:"f"`
+    H   byte     `json:"h"`
+    L   byte     `json:"l"`
+    Ram [][2]int `json:"ram"`
+}
+
+type sm83Test struct {
+    Name    string          `json:"name"`
+    Initial sm83State       `json:"initial"`
+    Final   sm83State       `json:"final"`
+    // One entry per machine cycle: address, data and pins, where the pins
+    // are "r-m" for a read, "-wm" for a write and "---" when the bus is idle
+    Cycles  [][]interface{} `json:"cycles"`
+}
+
+type busAccess struct {
+    loc   uint16
+    val   byte
+    write bool
+}
+
+func (access busAccess) String() string {
+    if access.write {
+        return fmt.Sprintf("write $%.2x to $%.4x", access.val, access.loc)
+    }
+
+    return fmt.Sprintf("read $%.2x from $%.4x", access.val, access.loc)
+}
+
+// 64KiB of plain memory with nothing mapped into it, which keeps a record of
+// every access
+type testBus struct {
+    mem      [0x10000]byte
+    accesses []busAccess
+}
+
+func (testBus *testBus) Read(loc uint16) byte {
+    val := testBus.mem[loc]
+    testBus.accesses = append(testBus.accesses, busAccess{loc, val, false})
+    return val
+}
+
+func (testBus *testBus) Peek(loc uint16) byte {
+    return testBus.mem[loc]
+}
+
+func (testBus *testBus) Write(loc uint16, val byte) {
+    testBus.mem[loc] = val
+    testBus.accesses = append(testBus.accesses, busAccess{loc, val, true})
+}
+
+func TestSm83(t *testing.T) {
+    if _, err := os.Stat(sm83Dir); err != nil {
+        t.Skip("no tests in " + sm83Dir)
+    }
+
+    for _, opCode := range sortedOpCodes(opCodeTable) {
+        if opCode == 0xCB {
+            continue
+        }
+
+        name := fmt.Sprintf("%.2x", opCode)
+        t.Run(name, func(t *testing.T) {
+            runSm83(t, name)
+        })
+    }
+
+    for _, opCode := range sortedOpCodes(cbOpCodeTable) {
+        name := fmt.Sprintf("cb %.2x", opCode)
+        t.Run(name, func(t *testing.T) {
+            runSm83(t, name)
+        })
+    }
+}
+
+func sortedOpCodes(table map[byte]func(cpu *Cpu) int) (opCodes []byte) {
+    for opCode := range table {
+        opCodes = append(opCodes, opCode)
+    }
+
+    sort.Slice(opCodes, func(i, j int) bool { return opCodes[i] < opCodes[j] })
+    return
+}
+
+func runSm83(t *testing.T, name string) {
+    data, err := ioutil.ReadFile(filepath.Join(sm83Dir, name+".json"))
+    if os.IsNotExist(err) {
+        t.Skip("no " + name + ".json")
+    } else if err != nil {
+        t.Fatal(err)
+    }
+
+    var tests []sm83Test
+    if err := json.Unmarshal(data, &tests); err != nil {
+        t.Fatal(err)
+    }
+
+    failed := 0
+
+    for _, test := range tests {
+        problems := runSm83Case(test)
+        if len(problems) == 0 {
+            continue
+        }
+
+        if failed < sm83MaxReports {
+            t.Errorf("%s:\n    %s", test.Name, strings.Join(problems, "\n    "))
+        }
+        failed++
+    }
+
+    if failed > sm83MaxReports {
+        t.Errorf("%d of %d cases failed", failed, len(tests))
+    }
+}
+
+// Runs one instruction and describes everything that came out differently
+func runSm83Case(test sm83Test) (problems []string) {
+    var (
+        cpu Cpu
+        bus testBus
+    )
+
+    cpu.Init(&bus)
+    setSm83State(&cpu, &bus, test.Initial)
+
+    cycles := cpu.Step()
+
+    report := func(format string, args ...interface{}) {
+        problems = append(problems, fmt.Sprintf(format, args...))
+    }
+
+    if cycles != len(test.Cycles)*4 {
+        report("took %d cycles, expected %d", cycles, len(test.Cycles)*4)
+    }
+
+    want := test.Final
+    got := sm83State{
+        PC: cpu.pcReg, SP: cpu.spReg,
+        A: cpu.aReg, B: cpu.bReg, C: cpu.cReg, D: cpu.dReg,
+        E: cpu.eReg, F: cpu.fReg, H: cpu.hReg, L: cpu.lReg,
+    }
+
+    for _, reg := range []struct {
+        name      string
+        got, want int
+    }{
+        {"a", int(got.A), int(want.A)}, {"f", int(got.F), int(want.F)},
+        {"b", int(got.B), int(want.B)}, {"c", int(got.C), int(want.C)},
+        {"d", int(got.D), int(want.D)}, {"e", int(got.E), int(want.E)},
+        {"h", int(got.H), int(want.H)}, {"l", int(got.L), int(want.L)},
+        {"sp", int(got.SP), int(want.SP)}, {"pc", int(got.PC), int(want.PC)},
+    } {
+        if reg.got != reg.want {
+            report("%s is $%.2x, expected $%.2x", reg.name, reg.got, reg.want)
+        }
+    }
+
+    for _, entry := range want.Ram {
+        if val := bus.mem[uint16(entry[0])]; val != byte(entry[1]) {
+            report("$%.4x is $%.2x, expected $%.2x", entry[0], val, entry[1])
+        }
+    }
+
+    var wantAccesses []busAccess
+    for _, cycle := range test.Cycles {
+        if access, ok := parseSm83Cycle(cycle); ok {
+            wantAccesses = append(wantAccesses, access)
+        }
+    }
+
+    for i := 0; i < len(bus.accesses) || i < len(wantAccesses); i++ {
+        switch {
+        case i >= len(wantAccesses):
+            report("bus access %d: unexpected %s", i, bus.accesses[i])
+        case i >= len(bus.accesses):
+            report("bus access %d: missing %s", i, wantAccesses[i])
+        case bus.accesses[i] != wantAccesses[i]:
+            report("bus access %d: %s, expected %s", i, bus.accesses[i], wantAccesses[i])
+        default:
+            continue
+        }
+
+        // Once the accesses are out of step the rest is just noise
+        break
+    }
+
+    return
+}
+
+func setSm83State(cpu *Cpu, bus *testBus, state sm83State) {
+    cpu.pcReg, cpu.spReg = state.PC, state.SP
+    cpu.aReg, cpu.fReg = state.A, state.F
+    cpu.bReg, cpu.cReg = state.B, state.C
+    cpu.dReg, cpu.eReg = state.D, state.E
+    cpu.hReg, cpu.lReg = state.H, state.L
+
+    for _, entry := range state.Ram {
+        bus.mem[uint16(entry[0])] = byte(entry[1])
+    }
+}
+
+// Idle cycles come back with ok false
+func parseSm83Cycle(cycle []interface{}) (access busAccess, ok bool) {
+    if len(cycle) < 3 {
+        return
+    }
+
+    loc, locOk := cycle[0].(float64)
+    val, valOk := cycle[1].(float64)
+    pins, pinsOk := cycle[2].(string)
+    if !locOk || !valOk || !pinsOk || len(pins) < 2 {
+        return
+    }
+
+    switch {
+    case pins[0] == 'r':
+        return busAccess{uint16(loc), byte(val), false}, true
+    case pins[1] == 'w':
+        return busAccess{uint16(loc), byte(val), true}, true
+    }
+
+    return
+}
@@ -0,0 +1,33 @@
+package cpu
+
+import (
+    "encoding/binary"
+    "io"
+)
+
+// Size in bytes of the serialized register file
+const stateSize = 12
+
+func (cpu *Cpu) SaveState(w io.Writer) error {
+    state := make([]byte, stateSize)
+    copy(state, []byte{cpu.aReg, cpu.fReg, cpu.bReg, cpu.cReg, cpu.dReg, cpu.eReg, cpu.hReg, cpu.lReg})
+    binary.LittleEndian.PutUint16(state[8:], cpu.spReg)
+    binary.LittleEndian.PutUint16(state[10:], cpu.pcReg)
+
+    _, err := w.Write(state)
+    return err
+}
+
+func (cpu *Cpu) LoadState(r io.Reader) error {
+    state := make([]byte, stateSize)
+    if _, err := io.ReadFull(r, state); err != nil {
+        return err
+    }
+
+    cpu.aReg, cpu.fReg, cpu.bReg, cpu.cReg = state[0], state[1], state[2], state[3]
+    cpu.dReg, cpu.eReg, cpu.hReg, cpu.lReg = state[4], state[5], state[6], state[7]
+    cpu.spReg = binary.LittleEndian.Uint16(state[8:])
+    cpu.pcReg = binary.LittleEndian.Uint16(state[10:])
+
+    return nil
+}
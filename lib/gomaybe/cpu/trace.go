@@ -0,0 +1,58 @@
+package cpu
+
+import (
+    "../disasm"
+    "fmt"
+    "io"
+)
+
+// Logs the registers before every instruction to the writer, one line each
+// in the format used by Gameboy Doctor:
+//
+//     A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100 PCMEM:00,C3,13,02
+//
+// A nil writer turns tracing off.
+func (cpu *Cpu) SetTracer(w io.Writer) {
+    cpu.tracer = w
+}
+
+// Appends the disassembled instruction to each trace line as a comment. The
+// result is easier to read but no longer diffs cleanly against reference
+// logs.
+func (cpu *Cpu) SetTraceDisassembly(on bool) {
+    cpu.traceDisasm = on
+}
+
+// Labels the disassembled instructions in the trace, when there are symbols
+func (cpu *Cpu) SetTraceNamer(namer disasm.Namer) {
+    cpu.traceNamer = namer
+}
+
+func (cpu *Cpu) trace() {
+    fmt.Fprintf(cpu.tracer, "A:%.2X F:%.2X B:%.2X C:%.2X D:%.2X E:%.2X H:%.2X L:%.2X SP:%.4X PC:%.4X PCMEM:%.2X,%.2X,%.2X,%.2X",
+        cpu.aReg, cpu.fReg, cpu.bReg, cpu.cReg, cpu.dReg, cpu.eReg, cpu.hReg, cpu.lReg, cpu.spReg, cpu.pcReg,
+        cpu.bus.Peek(cpu.pcReg), cpu.bus.Peek(cpu.pcReg+1), cpu.bus.Peek(cpu.pcReg+2), cpu.bus.Peek(cpu.pcReg+3))
+
+    if cpu.traceDisasm {
+        fmt.Fprint(cpu.tracer, " ; ")
+
+        if cpu.traceNamer != nil {
+            if location, ok := cpu.traceNamer.Locate(cpu.pcReg); ok {
+                fmt.Fprint(cpu.tracer, location+": ")
+            }
+        }
+
+        fmt.Fprint(cpu.tracer, disasm.DisassembleNamed(busPeeker{cpu.bus}, cpu.pcReg, cpu.traceNamer).Text)
+    }
+
+    fmt.Fprintln(cpu.tracer)
+}
+
+// Lets the disassembler look at the bus without the reads being seen
+type busPeeker struct {
+    bus Bus
+}
+
+func (peeker busPeeker) Read(loc uint16) byte {
+    return peeker.bus.Peek(loc)
+}
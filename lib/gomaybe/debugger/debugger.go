@@ -0,0 +1,746 @@
+package debugger
+
+import (
+    . "../cheats"
+    . "../disasm"
+    . "../gameboy"
+    . "../search"
+    . "../symbols"
+    "bufio"
+    "fmt"
+    "io"
+    "os"
+    "os/signal"
+    "strconv"
+    "strings"
+)
+
+const debuggerHelp = `Commands (numbers are decimal unless prefixed with 0x or $):
+  s [N]                step N instructions
+  n                    step over calls
+  c                    continue until a breakpoint or watchpoint, Ctrl-C to stop
+  b ADDR [if COND]     break at ADDR, optionally only when COND holds (b $0150 if a == 0x3c)
+                       ADDR can be a symbol (b main_loop), which only breaks in its bank
+  w ADDR [r|w|rw]      watch reads and/or writes of ADDR, writes by default
+  i                    list breakpoints and watchpoints
+  d N                  delete breakpoint or watchpoint N
+  r                    show registers
+  set REG VAL          change a register
+  p EXPR               print the value of an expression
+  x ADDR [LEN]         dump memory
+  poke ADDR VAL...     write memory
+  l [ADDR]             disassemble around PC or from ADDR
+  bt                   backtrace from the stack
+  search new [8|16]    start a RAM search over 8 or 16 bit values
+  search OP [N]        keep addresses whose value compares with OP (== != < > <= >=)
+                       to N, or to its value at the last search; +N or -N keeps
+                       those that went up or down by N
+  search list [N]      show the first N addresses left, 20 by default
+  search watch [ADDR]  watch writes to ADDR, or every address left
+  search freeze [ADDR] hold ADDR, or every address left, at its value with a cheat
+  q                    quit
+An empty line repeats the last command.`
+
+const (
+    // How many instructions to show when disassembling
+    listLength = 10
+    // How many stack words to look through for return addresses
+    backtraceDepth = 64
+    // How many search results to show or promote at once
+    searchLimit = 20
+)
+
+type breakpoint struct {
+    id       int
+    addr     uint16
+    // -1 for any bank
+    bank     int
+    condText string
+    cond     expr
+}
+
+type watchpoint struct {
+    id    int
+    addr  uint16
+    read  bool
+    write bool
+}
+
+type Debugger struct {
+    gameBoy     *GameBoy
+    out         io.Writer
+    symbols     *Symbols
+    namer       Namer
+    breakpoints []breakpoint
+    watchpoints []watchpoint
+    nextId      int
+    stepping    bool
+    hit         string
+    search      Search
+}
+
+func (debugger *Debugger) Init(gameBoy *GameBoy, out io.Writer) {
+    debugger.gameBoy = gameBoy
+    debugger.out = out
+    debugger.breakpoints = nil
+    debugger.watchpoints = nil
+    debugger.nextId = 1
+    debugger.search.Init(&gameBoy.Ram)
+
+    gameBoy.Ram.SetWatcher(debugger.watch)
+}
+
+// Lets symbols be used in expressions and shows them in disassembly and
+// backtraces
+func (debugger *Debugger) SetSymbols(symbols *Symbols) {
+    debugger.symbols = symbols
+    debugger.namer = symbols.Namer(debugger.gameBoy.BankAt)
+}
+
+func (debugger *Debugger) disassemble(addr uint16) Instruction {
+    return DisassembleNamed(&debugger.gameBoy.Ram, addr, debugger.namer)
+}
+
+// Describes an address as label+offset when there are symbols for it
+func (debugger *Debugger) locate(addr uint16) string {
+    if debugger.namer != nil {
+        if location, ok := debugger.namer.Locate(addr); ok {
+            return fmt.Sprintf("$%.4x <%s>", addr, location)
+        }
+    }
+
+    return fmt.Sprintf("$%.4x", addr)
+}
+
+// Reads commands until the input ends or the user quits
+func (debugger *Debugger) Run(in io.Reader) {
+    var lastLine string
+
+    scanner := bufio.NewScanner(in)
+    debugger.showLocation()
+
+    for {
+        fmt.Fprint(debugger.out, "(gmdb) ")
+        if !scanner.Scan() {
+            fmt.Fprintln(debugger.out)
+            return
+        }
+
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" {
+            line = lastLine
+        }
+
+        if line == "" {
+            continue
+        }
+
+        lastLine = line
+
+        if !debugger.command(line) {
+            return
+        }
+    }
+}
+
+// Returns false when the user quits
+func (debugger *Debugger) command(line string) bool {
+    args := strings.Fields(line)
+    rest := strings.TrimSpace(strings.TrimPrefix(line, args[0]))
+
+    switch args[0] {
+    case "s":
+        count := 1
+        if len(args) > 1 {
+            var err error
+            if count, err = strconv.Atoi(args[1]); err != nil || count < 1 {
+                fmt.Fprintln(debugger.out, "Invalid count: "+args[1])
+                break
+            }
+        }
+
+        for i := 0; i < count; i++ {
+            if !debugger.step() {
+                break
+            }
+        }
+
+        debugger.showLocation()
+    case "n":
+        debugger.next()
+        debugger.showLocation()
+    case "c":
+        debugger.cont()
+        debugger.showLocation()
+    case "b":
+        debugger.addBreakpoint(rest)
+    case "w":
+        debugger.addWatchpoint(args[1:])
+    case "i":
+        debugger.listPoints()
+    case "d":
+        if len(args) < 2 {
+            fmt.Fprintln(debugger.out, "Usage: d N")
+        } else if id, err := strconv.Atoi(args[1]); err != nil || !debugger.deletePoint(id) {
+            fmt.Fprintln(debugger.out, "No breakpoint or watchpoint "+args[1])
+        }
+    case "r":
+        debugger.showRegisters()
+    case "set":
+        if len(args) < 3 {
+            fmt.Fprintln(debugger.out, "Usage: set REG VAL")
+            break
+        }
+
+        val, ok := debugger.eval(strings.Join(args[2:], " "))
+        if ok && !debugger.gameBoy.Cpu.SetRegister(args[1], uint16(val)) {
+            fmt.Fprintln(debugger.out, "Unknown register: "+args[1])
+        }
+    case "p":
+        if val, ok := debugger.eval(rest); ok {
+            fmt.Fprintf(debugger.out, "%d ($%x)\n", val, val)
+        }
+    case "x":
+        debugger.dump(args[1:])
+    case "poke":
+        debugger.poke(args[1:])
+    case "l":
+        addr := debugger.gameBoy.Cpu.PC()
+        if len(args) > 1 {
+            val, ok := debugger.eval(rest)
+            if !ok {
+                break
+            }
+            addr = uint16(val)
+        }
+        debugger.list(addr, len(args) == 1)
+    case "bt":
+        debugger.backtrace()
+    case "search":
+        debugger.searchCommand(args[1:])
+    case "q":
+        return false
+    default:
+        fmt.Fprintln(debugger.out, debuggerHelp)
+    }
+
+    return true
+}
+
+// Evaluates an expression right away, printing any error
+func (debugger *Debugger) eval(text string) (int, bool) {
+    parsed, err := parseExpr(text, debugger.symbols)
+    if err != nil {
+        fmt.Fprintln(debugger.out, "Invalid expression: "+err.Error())
+        return 0, false
+    }
+
+    return parsed(debugger), true
+}
+
+func (debugger *Debugger) watch(loc uint16, val byte, write bool) {
+    if !debugger.stepping {
+        return
+    }
+
+    for _, watchpoint := range debugger.watchpoints {
+        if watchpoint.addr != loc {
+            continue
+        }
+
+        if write && watchpoint.write {
+            debugger.hit = fmt.Sprintf("Watchpoint %d: write $%.2x to $%.4x", watchpoint.id, val, loc)
+        } else if !write && watchpoint.read {
+            debugger.hit = fmt.Sprintf("Watchpoint %d: read $%.2x from $%.4x", watchpoint.id, val, loc)
+        }
+    }
+}
+
+// Runs one instruction, returning false if execution should stop
+func (debugger *Debugger) step() bool {
+    debugger.hit = ""
+    debugger.stepping = true
+    cycles := debugger.gameBoy.Step()
+    debugger.stepping = false
+
+    if cycles < 0 {
+        fmt.Fprintln(debugger.out, "Unknown opcode, execution can't continue")
+        return false
+    }
+
+    if debugger.hit != "" {
+        fmt.Fprintln(debugger.out, debugger.hit)
+        return false
+    }
+
+    return true
+}
+
+// Whether a breakpoint wants to stop at the current PC
+func (debugger *Debugger) atBreakpoint() bool {
+    pc := debugger.gameBoy.Cpu.PC()
+
+    for _, breakpoint := range debugger.breakpoints {
+        if breakpoint.addr != pc || breakpoint.bank >= 0 && breakpoint.bank != debugger.gameBoy.BankAt(pc) {
+            continue
+        }
+
+        if breakpoint.cond == nil || breakpoint.cond(debugger) != 0 {
+            fmt.Fprintf(debugger.out, "Breakpoint %d at %s\n", breakpoint.id, debugger.locate(pc))
+            return true
+        }
+    }
+
+    return false
+}
+
+// Steps until stop returns true, a breakpoint or watchpoint is hit or the
+// user interrupts
+func (debugger *Debugger) runUntil(stop func() bool) {
+    interrupted := make(chan os.Signal, 1)
+    signal.Notify(interrupted, os.Interrupt)
+    defer signal.Stop(interrupted)
+
+    for i := 0; ; i++ {
+        if !debugger.step() || stop() || debugger.atBreakpoint() {
+            return
+        }
+
+        // Checking for Ctrl-C every instruction would be slow
+        if i%0x1000 == 0 {
+            select {
+            case <-interrupted:
+                fmt.Fprintln(debugger.out, "Interrupted")
+                return
+            default:
+            }
+        }
+    }
+}
+
+func (debugger *Debugger) cont() {
+    debugger.runUntil(func() bool { return false })
+}
+
+// Steps over calls and restarts by running until they return
+func (debugger *Debugger) next() {
+    cpu := &debugger.gameBoy.Cpu
+    instruction := debugger.disassemble(cpu.PC())
+
+    if !strings.HasPrefix(instruction.Text, "call") && !strings.HasPrefix(instruction.Text, "rst") {
+        debugger.step()
+        return
+    }
+
+    returnAddr := cpu.PC() + uint16(instruction.Length)
+    sp := cpu.SP()
+
+    debugger.runUntil(func() bool {
+        return cpu.PC() == returnAddr && cpu.SP() >= sp
+    })
+}
+
+func (debugger *Debugger) addBreakpoint(text string) {
+    addrText, condText := text, ""
+    if i := strings.Index(text, " if "); i >= 0 {
+        addrText, condText = text[:i], strings.TrimSpace(text[i+4:])
+    }
+
+    if addrText == "" {
+        fmt.Fprintln(debugger.out, "Usage: b ADDR [if COND]")
+        return
+    }
+
+    addr, ok := debugger.eval(addrText)
+    if !ok {
+        return
+    }
+
+    breakpoint := breakpoint{id: debugger.nextId, addr: uint16(addr), bank: -1, condText: condText}
+
+    // Code in switchable banks shares addresses with every other bank, so a
+    // breakpoint on a label there only applies to the label's own bank
+    if debugger.symbols != nil && addr >= 0x4000 && addr < 0x8000 {
+        if symbol, ok := debugger.symbols.Lookup(addrText); ok {
+            breakpoint.bank = symbol.Bank
+        }
+    }
+
+    if condText != "" {
+        cond, err := parseExpr(condText, debugger.symbols)
+        if err != nil {
+            fmt.Fprintln(debugger.out, "Invalid condition: "+err.Error())
+            return
+        }
+        breakpoint.cond = cond
+    }
+
+    debugger.nextId++
+    debugger.breakpoints = append(debugger.breakpoints, breakpoint)
+    fmt.Fprintf(debugger.out, "Breakpoint %d at %s\n", breakpoint.id, debugger.locate(breakpoint.addr))
+}
+
+func (debugger *Debugger) searchCommand(args []string) {
+    search := &debugger.search
+
+    if len(args) < 1 {
+        fmt.Fprintln(debugger.out, "Usage: search new|list|watch|freeze|OP [N]")
+        return
+    }
+
+    if args[0] != "new" && search.Candidates() == nil {
+        fmt.Fprintln(debugger.out, "No search started, use search new")
+        return
+    }
+
+    switch args[0] {
+    case "new":
+        size := 1
+        if len(args) > 1 {
+            switch args[1] {
+            case "8":
+            case "16":
+                size = 2
+            default:
+                fmt.Fprintln(debugger.out, "Search size must be 8 or 16")
+                return
+            }
+        }
+
+        search.Start(size)
+        fmt.Fprintf(debugger.out, "%d addresses\n", len(search.Candidates()))
+    case "list":
+        limit := searchLimit
+        if len(args) > 1 {
+            var ok bool
+            if limit, ok = debugger.eval(args[1]); !ok {
+                return
+            }
+
+            if limit < 1 {
+                fmt.Fprintln(debugger.out, "Usage: search list [N], with N above 0")
+                return
+            }
+        }
+
+        candidates := search.Candidates()
+        for i, candidate := range candidates {
+            if i == limit {
+                fmt.Fprintf(debugger.out, "... and %d more\n", len(candidates)-limit)
+                break
+            }
+
+            fmt.Fprintf(debugger.out, "%s: %d ($%x), was %d\n", debugger.locate(candidate.Addr), candidate.Cur, candidate.Cur, candidate.Prev)
+        }
+    case "watch", "freeze":
+        addrs, ok := debugger.searchResults(args[1:])
+        if !ok {
+            return
+        }
+
+        for _, addr := range addrs {
+            for i := 0; i < search.Size(); i++ {
+                if args[0] == "watch" {
+                    debugger.addWatchpoint([]string{fmt.Sprint(int(addr) + i)})
+                } else {
+                    debugger.freeze(addr + uint16(i))
+                }
+            }
+        }
+    default:
+        operand := ""
+        if len(args) > 1 {
+            val, ok := debugger.eval(strings.Join(args[1:], " "))
+            if !ok {
+                return
+            }
+            operand = fmt.Sprint(val)
+        }
+
+        keep, err := ParseComparison(args[0], operand)
+        if err != nil {
+            fmt.Fprintln(debugger.out, "Error: "+err.Error())
+            return
+        }
+
+        fmt.Fprintf(debugger.out, "%d addresses left\n", search.Filter(keep))
+    }
+}
+
+// The address given, or every address left in the search if there aren't
+// too many
+func (debugger *Debugger) searchResults(args []string) ([]uint16, bool) {
+    if len(args) > 0 {
+        addr, ok := debugger.eval(args[0])
+        return []uint16{uint16(addr)}, ok
+    }
+
+    candidates := debugger.search.Candidates()
+    if len(candidates) > searchLimit {
+        fmt.Fprintf(debugger.out, "%d addresses left, narrow the search down to %d or give an address\n", len(candidates), searchLimit)
+        return nil, false
+    }
+
+    var addrs []uint16
+    for _, candidate := range candidates {
+        addrs = append(addrs, candidate.Addr)
+    }
+
+    return addrs, true
+}
+
+// Adds a GameShark code that writes the current value back every frame
+func (debugger *Debugger) freeze(addr uint16) {
+    val := debugger.gameBoy.Ram.Peek(addr)
+
+    cheat, err := ParseCheat(fmt.Sprintf("01%.2X%.2X%.2X", val, addr&0xFF, addr>>8))
+    if err != nil {
+        fmt.Fprintln(debugger.out, "Error: "+err.Error())
+        return
+    }
+    cheat.Name = "search"
+
+    debugger.gameBoy.Cheats.Add(cheat)
+    fmt.Fprintf(debugger.out, "Freezing %s at $%.2x with %s\n", debugger.locate(addr), val, cheat.Code)
+}
+
+func (debugger *Debugger) addWatchpoint(args []string) {
+    if len(args) < 1 {
+        fmt.Fprintln(debugger.out, "Usage: w ADDR [r|w|rw]")
+        return
+    }
+
+    addr, ok := debugger.eval(args[0])
+    if !ok {
+        return
+    }
+
+    watchpoint := watchpoint{id: debugger.nextId, addr: uint16(addr), write: true}
+
+    if len(args) > 1 {
+        switch args[1] {
+        case "r":
+            watchpoint.read, watchpoint.write = true, false
+        case "w":
+        case "rw":
+            watchpoint.read = true
+        default:
+            fmt.Fprintln(debugger.out, "Watch mode must be r, w or rw")
+            return
+        }
+    }
+
+    debugger.nextId++
+    debugger.watchpoints = append(debugger.watchpoints, watchpoint)
+    fmt.Fprintf(debugger.out, "Watchpoint %d at $%.4x\n", watchpoint.id, watchpoint.addr)
+}
+
+func (debugger *Debugger) listPoints() {
+    for _, breakpoint := range debugger.breakpoints {
+        where := debugger.locate(breakpoint.addr)
+        if breakpoint.bank >= 0 {
+            where += fmt.Sprintf(" in bank %d", breakpoint.bank)
+        }
+
+        if breakpoint.condText != "" {
+            fmt.Fprintf(debugger.out, "%3d  break %s if %s\n", breakpoint.id, where, breakpoint.condText)
+        } else {
+            fmt.Fprintf(debugger.out, "%3d  break %s\n", breakpoint.id, where)
+        }
+    }
+
+    for _, watchpoint := range debugger.watchpoints {
+        mode := "w"
+        if watchpoint.read && watchpoint.write {
+            mode = "rw"
+        } else if watchpoint.read {
+            mode = "r"
+        }
+
+        fmt.Fprintf(debugger.out, "%3d  watch %s %s\n", watchpoint.id, debugger.locate(watchpoint.addr), mode)
+    }
+}
+
+func (debugger *Debugger) deletePoint(id int) bool {
+    for i, breakpoint := range debugger.breakpoints {
+        if breakpoint.id == id {
+            debugger.breakpoints = append(debugger.breakpoints[:i], debugger.breakpoints[i+1:]...)
+            return true
+        }
+    }
+
+    for i, watchpoint := range debugger.watchpoints {
+        if watchpoint.id == id {
+            debugger.watchpoints = append(debugger.watchpoints[:i], debugger.watchpoints[i+1:]...)
+            return true
+        }
+    }
+
+    return false
+}
+
+func (debugger *Debugger) showRegisters() {
+    cpu := &debugger.gameBoy.Cpu
+
+    af, _ := cpu.Register("af")
+    bc, _ := cpu.Register("bc")
+    de, _ := cpu.Register("de")
+    hl, _ := cpu.Register("hl")
+
+    flags := []byte("----")
+    for i, name := range "ZNHC" {
+        if af&(0x80>>uint(i)) != 0 {
+            flags[i] = byte(name)
+        }
+    }
+
+    fmt.Fprintf(debugger.out, "af=%.4x bc=%.4x de=%.4x hl=%.4x sp=%.4x pc=%.4x flags=%s frame=%d\n",
+        af, bc, de, hl, cpu.SP(), cpu.PC(), flags, debugger.gameBoy.Frames())
+}
+
+func (debugger *Debugger) showLocation() {
+    pc := debugger.gameBoy.Cpu.PC()
+
+    if debugger.namer != nil {
+        if location, ok := debugger.namer.Locate(pc); ok {
+            fmt.Fprintln(debugger.out, location+":")
+        }
+    }
+
+    fmt.Fprintln(debugger.out, debugger.disassemble(pc))
+}
+
+func (debugger *Debugger) dump(args []string) {
+    if len(args) < 1 {
+        fmt.Fprintln(debugger.out, "Usage: x ADDR [LEN]")
+        return
+    }
+
+    addr, ok := debugger.eval(args[0])
+    if !ok {
+        return
+    }
+
+    length := 64
+    if len(args) > 1 {
+        if length, ok = debugger.eval(args[1]); !ok {
+            return
+        }
+    }
+
+    ram := &debugger.gameBoy.Ram
+
+    for row := 0; row < length; row += 16 {
+        var hex, text strings.Builder
+
+        for col := 0; col < 16 && row+col < length; col++ {
+            val := ram.Read(uint16(addr + row + col))
+            fmt.Fprintf(&hex, "%.2x ", val)
+
+            if val >= 0x20 && val < 0x7F {
+                text.WriteByte(val)
+            } else {
+                text.WriteByte('.')
+            }
+        }
+
+        fmt.Fprintf(debugger.out, "$%.4x: %-48s %s\n", uint16(addr+row), hex.String(), text.String())
+    }
+}
+
+func (debugger *Debugger) poke(args []string) {
+    if len(args) < 2 {
+        fmt.Fprintln(debugger.out, "Usage: poke ADDR VAL...")
+        return
+    }
+
+    addr, ok := debugger.eval(args[0])
+    if !ok {
+        return
+    }
+
+    for i, arg := range args[1:] {
+        val, ok := debugger.eval(arg)
+        if !ok {
+            return
+        }
+
+        debugger.gameBoy.Ram.Write(uint16(addr+i), byte(val))
+    }
+}
+
+// Lists instructions from addr. Around the PC this tries to show a few
+// instructions leading up to it too, by finding an earlier address that
+// decodes into the PC.
+func (debugger *Debugger) list(addr uint16, aroundPc bool) {
+    pc := debugger.gameBoy.Cpu.PC()
+    start := addr
+
+    if aroundPc {
+        for back := 9; back > 0; back-- {
+            loc, count := int(pc)-back, 0
+            if loc < 0 {
+                continue
+            }
+
+            for ; loc < int(pc); count++ {
+                loc += debugger.disassemble(uint16(loc)).Length
+            }
+
+            if loc == int(pc) && count <= 3 {
+                start = uint16(int(pc) - back)
+                break
+            }
+        }
+    }
+
+    for i, loc := 0, start; i < listLength; i++ {
+        instruction := debugger.disassemble(loc)
+
+        if debugger.namer != nil {
+            if name, ok := debugger.namer.Name(loc); ok {
+                fmt.Fprintln(debugger.out, name+":")
+            }
+        }
+
+        marker := "  "
+        if loc == pc {
+            marker = "=>"
+        }
+
+        fmt.Fprintf(debugger.out, "%s %s\n", marker, instruction)
+        loc += uint16(instruction.Length)
+    }
+}
+
+// Walks up the stack looking for words that point just past a call or
+// restart. Anything else pushed on the stack can fool this, so it's a best
+// guess.
+func (debugger *Debugger) backtrace() {
+    ram := &debugger.gameBoy.Ram
+    cpu := &debugger.gameBoy.Cpu
+
+    fmt.Fprintf(debugger.out, "#0  %s\n", debugger.locate(cpu.PC()))
+
+    frame := 1
+    for i, sp := 0, cpu.SP(); i < backtraceDepth && sp < 0xFFFE; i, sp = i+1, sp+2 {
+        ret := ram.ReadWord(sp)
+
+        if call, ok := debugger.callBefore(ret); ok {
+            fmt.Fprintf(debugger.out, "#%-2d %s  %s  (return address at $%.4x)\n", frame, debugger.locate(call.Addr), call.Text, sp)
+            frame++
+        }
+    }
+}
+
+func (debugger *Debugger) callBefore(ret uint16) (Instruction, bool) {
+    if call := debugger.disassemble(ret - 3); call.Length == 3 && strings.HasPrefix(call.Text, "call") {
+        return call, true
+    }
+
+    if rst := debugger.disassemble(ret - 1); strings.HasPrefix(rst.Text, "rst") {
+        return rst, true
+    }
+
+    return Instruction{}, false
+}
@@ -0,0 +1,297 @@
+package debugger
+
+import (
+    . "../cpu"
+    . "../symbols"
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+// A parsed expression, evaluated against the machine each time it's called.
+//
+// Expressions are made of numbers (decimal, or hex with a 0x or $ prefix),
+// register names, symbols, memory reads written as [addr], parentheses and
+// the operators || && == != < <= > >= + - & | and unary ! and -.
+type expr func(debugger *Debugger) int
+
+type parser struct {
+    tokens  []string
+    pos     int
+    symbols *Symbols
+}
+
+var operators = []string{"||", "&&", "==", "!=", "<=", ">=", "<", ">", "+", "-", "&", "|", "!", "[", "]", "(", ")"}
+
+// Symbols are resolved to their addresses as the expression is parsed.
+// symbols may be nil.
+func parseExpr(text string, symbols *Symbols) (expr, error) {
+    tokens, err := tokenize(text)
+    if err != nil {
+        return nil, err
+    }
+
+    if len(tokens) == 0 {
+        return nil, errors.New("empty expression")
+    }
+
+    parser := parser{tokens: tokens, symbols: symbols}
+    result, err := parser.or()
+    if err != nil {
+        return nil, err
+    }
+
+    if parser.pos < len(parser.tokens) {
+        return nil, fmt.Errorf("unexpected %q", parser.tokens[parser.pos])
+    }
+
+    return result, nil
+}
+
+func tokenize(text string) (tokens []string, err error) {
+    for text = strings.TrimSpace(text); text != ""; text = strings.TrimSpace(text) {
+        matched := false
+
+        for _, op := range operators {
+            if strings.HasPrefix(text, op) {
+                tokens = append(tokens, op)
+                text = text[len(op):]
+                matched = true
+                break
+            }
+        }
+
+        if matched {
+            continue
+        }
+
+        end := strings.IndexFunc(text, func(r rune) bool {
+            return !(r == '$' || r == '_' || r == '.' || r == ':' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+        })
+
+        if end == 0 {
+            return nil, fmt.Errorf("unexpected %q", text[:1])
+        } else if end < 0 {
+            end = len(text)
+        }
+
+        tokens = append(tokens, text[:end])
+        text = text[end:]
+    }
+
+    return
+}
+
+func (parser *parser) peek() string {
+    if parser.pos < len(parser.tokens) {
+        return parser.tokens[parser.pos]
+    }
+
+    return ""
+}
+
+func (parser *parser) next() string {
+    token := parser.peek()
+    parser.pos++
+    return token
+}
+
+func (parser *parser) or() (expr, error) {
+    left, err := parser.and()
+
+    for err == nil && parser.peek() == "||" {
+        parser.next()
+
+        var right expr
+        if right, err = parser.and(); err == nil {
+            left = binary(left, right, func(a, b int) int { return boolInt(a != 0 || b != 0) })
+        }
+    }
+
+    return left, err
+}
+
+func (parser *parser) and() (expr, error) {
+    left, err := parser.comparison()
+
+    for err == nil && parser.peek() == "&&" {
+        parser.next()
+
+        var right expr
+        if right, err = parser.comparison(); err == nil {
+            left = binary(left, right, func(a, b int) int { return boolInt(a != 0 && b != 0) })
+        }
+    }
+
+    return left, err
+}
+
+func (parser *parser) comparison() (expr, error) {
+    left, err := parser.sum()
+    if err != nil {
+        return nil, err
+    }
+
+    var compare func(a, b int) int
+
+    switch parser.peek() {
+    case "==":
+        compare = func(a, b int) int { return boolInt(a == b) }
+    case "!=":
+        compare = func(a, b int) int { return boolInt(a != b) }
+    case "<":
+        compare = func(a, b int) int { return boolInt(a < b) }
+    case "<=":
+        compare = func(a, b int) int { return boolInt(a <= b) }
+    case ">":
+        compare = func(a, b int) int { return boolInt(a > b) }
+    case ">=":
+        compare = func(a, b int) int { return boolInt(a >= b) }
+    default:
+        return left, nil
+    }
+
+    parser.next()
+
+    right, err := parser.sum()
+    if err != nil {
+        return nil, err
+    }
+
+    return binary(left, right, compare), nil
+}
+
+func (parser *parser) sum() (expr, error) {
+    left, err := parser.unary()
+
+    for err == nil {
+        var op func(a, b int) int
+
+        switch parser.peek() {
+        case "+":
+            op = func(a, b int) int { return a + b }
+        case "-":
+            op = func(a, b int) int { return a - b }
+        case "&":
+            op = func(a, b int) int { return a & b }
+        case "|":
+            op = func(a, b int) int { return a | b }
+        default:
+            return left, nil
+        }
+
+        parser.next()
+
+        var right expr
+        if right, err = parser.unary(); err == nil {
+            left = binary(left, right, op)
+        }
+    }
+
+    return nil, err
+}
+
+func (parser *parser) unary() (expr, error) {
+    switch parser.peek() {
+    case "!":
+        parser.next()
+        operand, err := parser.unary()
+        if err != nil {
+            return nil, err
+        }
+        return func(debugger *Debugger) int { return boolInt(operand(debugger) == 0) }, nil
+    case "-":
+        parser.next()
+        operand, err := parser.unary()
+        if err != nil {
+            return nil, err
+        }
+        return func(debugger *Debugger) int { return -operand(debugger) }, nil
+    }
+
+    return parser.primary()
+}
+
+func (parser *parser) primary() (expr, error) {
+    token := parser.next()
+
+    switch token {
+    case "":
+        return nil, errors.New("unexpected end of expression")
+    case "(":
+        inner, err := parser.or()
+        if err != nil {
+            return nil, err
+        }
+        if parser.next() != ")" {
+            return nil, errors.New("missing )")
+        }
+        return inner, nil
+    case "[":
+        addr, err := parser.or()
+        if err != nil {
+            return nil, err
+        }
+        if parser.next() != "]" {
+            return nil, errors.New("missing ]")
+        }
+        return func(debugger *Debugger) int {
+            return int(debugger.gameBoy.Ram.Read(uint16(addr(debugger))))
+        }, nil
+    }
+
+    if val, ok := parseNumber(token); ok {
+        return func(debugger *Debugger) int { return val }, nil
+    }
+
+    for _, name := range RegisterNames {
+        if strings.EqualFold(token, name) {
+            return func(debugger *Debugger) int {
+                val, _ := debugger.gameBoy.Cpu.Register(name)
+                return int(val)
+            }, nil
+        }
+    }
+
+    if parser.symbols != nil {
+        if symbol, ok := parser.symbols.Lookup(token); ok {
+            addr := int(symbol.Addr)
+            return func(debugger *Debugger) int { return addr }, nil
+        }
+    }
+
+    return nil, fmt.Errorf("unknown name %q", token)
+}
+
+func parseNumber(token string) (int, bool) {
+    var (
+        val uint64
+        err error
+    )
+
+    switch {
+    case strings.HasPrefix(token, "$"):
+        val, err = strconv.ParseUint(token[1:], 16, 32)
+    case strings.HasPrefix(token, "0x"), strings.HasPrefix(token, "0X"):
+        val, err = strconv.ParseUint(token[2:], 16, 32)
+    default:
+        val, err = strconv.ParseUint(token, 10, 32)
+    }
+
+    return int(val), err == nil
+}
+
+func binary(left expr, right expr, op func(a, b int) int) expr {
+    return func(debugger *Debugger) int {
+        return op(left(debugger), right(debugger))
+    }
+}
+
+func boolInt(b bool) int {
+    if b {
+        return 1
+    }
+
+    return 0
+}
@@ -0,0 +1,425 @@
+package disasm
+
+import (
+    "fmt"
+    "strings"
+)
+
+// Anything memory can be read through, such as Ram
+type Reader interface {
+    Read(loc uint16) byte
+}
+
+// Gives names to addresses, such as labels from a symbol file
+type Namer interface {
+    // The label at exactly addr
+    Name(addr uint16) (string, bool)
+    // The closest label at or before addr, with an offset if it isn't
+    // exact, e.g. main_loop+3
+    Locate(addr uint16) (string, bool)
+}
+
+type Instruction struct {
+    Addr   uint16
+    Bytes  []byte
+    Text   string
+    Length int
+    // Cycles taken when a conditional branch isn't, CyclesTaken when it is.
+    // The two are the same for everything else.
+    Cycles      int
+    CyclesTaken int
+    // Where jumps, calls and restarts go, when known ahead of time
+    Target    uint16
+    HasTarget bool
+    Valid     bool
+}
+
+type opInfo struct {
+    text        string
+    length      int
+    cycles      int
+    cyclesTaken int
+}
+
+// Operands are filled in for these placeholders:
+//
+//     n8   8-bit immediate
+//     n16  16-bit immediate
+//     a16  16-bit address
+//     a8   low byte of an address in $FF00-$FFFF
+//     e8   signed offset for relative jumps, shown as the destination
+//     s8   signed immediate
+var opTable = [256]opInfo{
+    0x00: {"nop", 1, 4, 0},
+    0x01: {"ld bc, n16", 3, 12, 0},
+    0x02: {"ld [bc], a", 1, 8, 0},
+    0x03: {"inc bc", 1, 8, 0},
+    0x04: {"inc b", 1, 4, 0},
+    0x05: {"dec b", 1, 4, 0},
+    0x06: {"ld b, n8", 2, 8, 0},
+    0x07: {"rlca", 1, 4, 0},
+    0x08: {"ld [a16], sp", 3, 20, 0},
+    0x09: {"add hl, bc", 1, 8, 0},
+    0x0A: {"ld a, [bc]", 1, 8, 0},
+    0x0B: {"dec bc", 1, 8, 0},
+    0x0C: {"inc c", 1, 4, 0},
+    0x0D: {"dec c", 1, 4, 0},
+    0x0E: {"ld c, n8", 2, 8, 0},
+    0x0F: {"rrca", 1, 4, 0},
+    0x10: {"stop", 2, 4, 0},
+    0x11: {"ld de, n16", 3, 12, 0},
+    0x12: {"ld [de], a", 1, 8, 0},
+    0x13: {"inc de", 1, 8, 0},
+    0x14: {"inc d", 1, 4, 0},
+    0x15: {"dec d", 1, 4, 0},
+    0x16: {"ld d, n8", 2, 8, 0},
+    0x17: {"rla", 1, 4, 0},
+    0x18: {"jr e8", 2, 12, 0},
+    0x19: {"add hl, de", 1, 8, 0},
+    0x1A: {"ld a, [de]", 1, 8, 0},
+    0x1B: {"dec de", 1, 8, 0},
+    0x1C: {"inc e", 1, 4, 0},
+    0x1D: {"dec e", 1, 4, 0},
+    0x1E: {"ld e, n8", 2, 8, 0},
+    0x1F: {"rra", 1, 4, 0},
+    0x20: {"jr nz, e8", 2, 8, 12},
+    0x21: {"ld hl, n16", 3, 12, 0},
+    0x22: {"ld [hl+], a", 1, 8, 0},
+    0x23: {"inc hl", 1, 8, 0},
+    0x24: {"inc h", 1, 4, 0},
+    0x25: {"dec h", 1, 4, 0},
+    0x26: {"ld h, n8", 2, 8, 0},
+    0x27: {"daa", 1, 4, 0},
+    0x28: {"jr z, e8", 2, 8, 12},
+    0x29: {"add hl, hl", 1, 8, 0},
+    0x2A: {"ld a, [hl+]", 1, 8, 0},
+    0x2B: {"dec hl", 1, 8, 0},
+    0x2C: {"inc l", 1, 4, 0},
+    0x2D: {"dec l", 1, 4, 0},
+    0x2E: {"ld l, n8", 2, 8, 0},
+    0x2F: {"cpl", 1, 4, 0},
+    0x30: {"jr nc, e8", 2, 8, 12},
+    0x31: {"ld sp, n16", 3, 12, 0},
+    0x32: {"ld [hl-], a", 1, 8, 0},
+    0x33: {"inc sp", 1, 8, 0},
+    0x34: {"inc [hl]", 1, 12, 0},
+    0x35: {"dec [hl]", 1, 12, 0},
+    0x36: {"ld [hl], n8", 2, 12, 0},
+    0x37: {"scf", 1, 4, 0},
+    0x38: {"jr c, e8", 2, 8, 12},
+    0x39: {"add hl, sp", 1, 8, 0},
+    0x3A: {"ld a, [hl-]", 1, 8, 0},
+    0x3B: {"dec sp", 1, 8, 0},
+    0x3C: {"inc a", 1, 4, 0},
+    0x3D: {"dec a", 1, 4, 0},
+    0x3E: {"ld a, n8", 2, 8, 0},
+    0x3F: {"ccf", 1, 4, 0},
+    0x40: {"ld b, b", 1, 4, 0},
+    0x41: {"ld b, c", 1, 4, 0},
+    0x42: {"ld b, d", 1, 4, 0},
+    0x43: {"ld b, e", 1, 4, 0},
+    0x44: {"ld b, h", 1, 4, 0},
+    0x45: {"ld b, l", 1, 4, 0},
+    0x46: {"ld b, [hl]", 1, 8, 0},
+    0x47: {"ld b, a", 1, 4, 0},
+    0x48: {"ld c, b", 1, 4, 0},
+    0x49: {"ld c, c", 1, 4, 0},
+    0x4A: {"ld c, d", 1, 4, 0},
+    0x4B: {"ld c, e", 1, 4, 0},
+    0x4C: {"ld c, h", 1, 4, 0},
+    0x4D: {"ld c, l", 1, 4, 0},
+    0x4E: {"ld c, [hl]", 1, 8, 0},
+    0x4F: {"ld c, a", 1, 4, 0},
+    0x50: {"ld d, b", 1, 4, 0},
+    0x51: {"ld d, c", 1, 4, 0},
+    0x52: {"ld d, d", 1, 4, 0},
+    0x53: {"ld d, e", 1, 4, 0},
+    0x54: {"ld d, h", 1, 4, 0},
+    0x55: {"ld d, l", 1, 4, 0},
+    0x56: {"ld d, [hl]", 1, 8, 0},
+    0x57: {"ld d, a", 1, 4, 0},
+    0x58: {"ld e, b", 1, 4, 0},
+    0x59: {"ld e, c", 1, 4, 0},
+    0x5A: {"ld e, d", 1, 4, 0},
+    0x5B: {"ld e, e", 1, 4, 0},
+    0x5C: {"ld e, h", 1, 4, 0},
+    0x5D: {"ld e, l", 1, 4, 0},
+    0x5E: {"ld e, [hl]", 1, 8, 0},
+    0x5F: {"ld e, a", 1, 4, 0},
+    0x60: {"ld h, b", 1, 4, 0},
+    0x61: {"ld h, c", 1, 4, 0},
+    0x62: {"ld h, d", 1, 4, 0},
+    0x63: {"ld h, e", 1, 4, 0},
+    0x64: {"ld h, h", 1, 4, 0},
+    0x65: {"ld h, l", 1, 4, 0},
+    0x66: {"ld h, [hl]", 1, 8, 0},
+    0x67: {"ld h, a", 1, 4, 0},
+    0x68: {"ld l, b", 1, 4, 0},
+    0x69: {"ld l, c", 1, 4, 0},
+    0x6A: {"ld l, d", 1, 4, 0},
+    0x6B: {"ld l, e", 1, 4, 0},
+    0x6C: {"ld l, h", 1, 4, 0},
+    0x6D: {"ld l, l", 1, 4, 0},
+    0x6E: {"ld l, [hl]", 1, 8, 0},
+    0x6F: {"ld l, a", 1, 4, 0},
+    0x70: {"ld [hl], b", 1, 8, 0},
+    0x71: {"ld [hl], c", 1, 8, 0},
+    0x72: {"ld [hl], d", 1, 8, 0},
+    0x73: {"ld [hl], e", 1, 8, 0},
+    0x74: {"ld [hl], h", 1, 8, 0},
+    0x75: {"ld [hl], l", 1, 8, 0},
+    0x76: {"halt", 1, 4, 0},
+    0x77: {"ld [hl], a", 1, 8, 0},
+    0x78: {"ld a, b", 1, 4, 0},
+    0x79: {"ld a, c", 1, 4, 0},
+    0x7A: {"ld a, d", 1, 4, 0},
+    0x7B: {"ld a, e", 1, 4, 0},
+    0x7C: {"ld a, h", 1, 4, 0},
+    0x7D: {"ld a, l", 1, 4, 0},
+    0x7E: {"ld a, [hl]", 1, 8, 0},
+    0x7F: {"ld a, a", 1, 4, 0},
+    0x80: {"add a, b", 1, 4, 0},
+    0x81: {"add a, c", 1, 4, 0},
+    0x82: {"add a, d", 1, 4, 0},
+    0x83: {"add a, e", 1, 4, 0},
+    0x84: {"add a, h", 1, 4, 0},
+    0x85: {"add a, l", 1, 4, 0},
+    0x86: {"add a, [hl]", 1, 8, 0},
+    0x87: {"add a, a", 1, 4, 0},
+    0x88: {"adc a, b", 1, 4, 0},
+    0x89: {"adc a, c", 1, 4, 0},
+    0x8A: {"adc a, d", 1, 4, 0},
+    0x8B: {"adc a, e", 1, 4, 0},
+    0x8C: {"adc a, h", 1, 4, 0},
+    0x8D: {"adc a, l", 1, 4, 0},
+    0x8E: {"adc a, [hl]", 1, 8, 0},
+    0x8F: {"adc a, a", 1, 4, 0},
+    0x90: {"sub a, b", 1, 4, 0},
+    0x91: {"sub a, c", 1, 4, 0},
+    0x92: {"sub a, d", 1, 4, 0},
+    0x93: {"sub a, e", 1, 4, 0},
+    0x94: {"sub a, h", 1, 4, 0},
+    0x95: {"sub a, l", 1, 4, 0},
+    0x96: {"sub a, [hl]", 1, 8, 0},
+    0x97: {"sub a, a", 1, 4, 0},
+    0x98: {"sbc a, b", 1, 4, 0},
+    0x99: {"sbc a, c", 1, 4, 0},
+    0x9A: {"sbc a, d", 1, 4, 0},
+    0x9B: {"sbc a, e", 1, 4, 0},
+    0x9C: {"sbc a, h", 1, 4, 0},
+    0x9D: {"sbc a, l", 1, 4, 0},
+    0x9E: {"sbc a, [hl]", 1, 8, 0},
+    0x9F: {"sbc a, a", 1, 4, 0},
+    0xA0: {"and a, b", 1, 4, 0},
+    0xA1: {"and a, c", 1, 4, 0},
+    0xA2: {"and a, d", 1, 4, 0},
+    0xA3: {"and a, e", 1, 4, 0},
+    0xA4: {"and a, h", 1, 4, 0},
+    0xA5: {"and a, l", 1, 4, 0},
+    0xA6: {"and a, [hl]", 1, 8, 0},
+    0xA7: {"and a, a", 1, 4, 0},
+    0xA8: {"xor a, b", 1, 4, 0},
+    0xA9: {"xor a, c", 1, 4, 0},
+    0xAA: {"xor a, d", 1, 4, 0},
+    0xAB: {"xor a, e", 1, 4, 0},
+    0xAC: {"xor a, h", 1, 4, 0},
+    0xAD: {"xor a, l", 1, 4, 0},
+    0xAE: {"xor a, [hl]", 1, 8, 0},
+    0xAF: {"xor a, a", 1, 4, 0},
+    0xB0: {"or a, b", 1, 4, 0},
+    0xB1: {"or a, c", 1, 4, 0},
+    0xB2: {"or a, d", 1, 4, 0},
+    0xB3: {"or a, e", 1, 4, 0},
+    0xB4: {"or a, h", 1, 4, 0},
+    0xB5: {"or a, l", 1, 4, 0},
+    0xB6: {"or a, [hl]", 1, 8, 0},
+    0xB7: {"or a, a", 1, 4, 0},
+    0xB8: {"cp a, b", 1, 4, 0},
+    0xB9: {"cp a, c", 1, 4, 0},
+    0xBA: {"cp a, d", 1, 4, 0},
+    0xBB: {"cp a, e", 1, 4, 0},
+    0xBC: {"cp a, h", 1, 4, 0},
+    0xBD: {"cp a, l", 1, 4, 0},
+    0xBE: {"cp a, [hl]", 1, 8, 0},
+    0xBF: {"cp a, a", 1, 4, 0},
+    0xC0: {"ret nz", 1, 8, 20},
+    0xC1: {"pop bc", 1, 12, 0},
+    0xC2: {"jp nz, a16", 3, 12, 16},
+    0xC3: {"jp a16", 3, 16, 0},
+    0xC4: {"call nz, a16", 3, 12, 24},
+    0xC5: {"push bc", 1, 16, 0},
+    0xC6: {"add a, n8", 2, 8, 0},
+    0xC7: {"rst $00", 1, 16, 0},
+    0xC8: {"ret z", 1, 8, 20},
+    0xC9: {"ret", 1, 16, 0},
+    0xCA: {"jp z, a16", 3, 12, 16},
+    0xCB: {"prefix", 2, 4, 0},
+    0xCC: {"call z, a16", 3, 12, 24},
+    0xCD: {"call a16", 3, 24, 0},
+    0xCE: {"adc a, n8", 2, 8, 0},
+    0xCF: {"rst $08", 1, 16, 0},
+    0xD0: {"ret nc", 1, 8, 20},
+    0xD1: {"pop de", 1, 12, 0},
+    0xD2: {"jp nc, a16", 3, 12, 16},
+    0xD4: {"call nc, a16", 3, 12, 24},
+    0xD5: {"push de", 1, 16, 0},
+    0xD6: {"sub a, n8", 2, 8, 0},
+    0xD7: {"rst $10", 1, 16, 0},
+    0xD8: {"ret c", 1, 8, 20},
+    0xD9: {"reti", 1, 16, 0},
+    0xDA: {"jp c, a16", 3, 12, 16},
+    0xDC: {"call c, a16", 3, 12, 24},
+    0xDE: {"sbc a, n8", 2, 8, 0},
+    0xDF: {"rst $18", 1, 16, 0},
+    0xE0: {"ldh [a8], a", 2, 12, 0},
+    0xE1: {"pop hl", 1, 12, 0},
+    0xE2: {"ldh [c], a", 1, 8, 0},
+    0xE5: {"push hl", 1, 16, 0},
+    0xE6: {"and a, n8", 2, 8, 0},
+    0xE7: {"rst $20", 1, 16, 0},
+    0xE8: {"add sp, s8", 2, 16, 0},
+    0xE9: {"jp hl", 1, 4, 0},
+    0xEA: {"ld [a16], a", 3, 16, 0},
+    0xEE: {"xor a, n8", 2, 8, 0},
+    0xEF: {"rst $28", 1, 16, 0},
+    0xF0: {"ldh a, [a8]", 2, 12, 0},
+    0xF1: {"pop af", 1, 12, 0},
+    0xF2: {"ldh a, [c]", 1, 8, 0},
+    0xF3: {"di", 1, 4, 0},
+    0xF5: {"push af", 1, 16, 0},
+    0xF6: {"or a, n8", 2, 8, 0},
+    0xF7: {"rst $30", 1, 16, 0},
+    0xF8: {"ld hl, sp+s8", 2, 12, 0},
+    0xF9: {"ld sp, hl", 1, 8, 0},
+    0xFA: {"ld a, [a16]", 3, 16, 0},
+    0xFB: {"ei", 1, 4, 0},
+    0xFE: {"cp a, n8", 2, 8, 0},
+    0xFF: {"rst $38", 1, 16, 0},
+}
+
+var (
+    cbRegs = []string{"b", "c", "d", "e", "h", "l", "[hl]", "a"}
+    cbOps  = []string{"rlc", "rrc", "rl", "rr", "sla", "sra", "swap", "srl"}
+)
+
+func Disassemble(mem Reader, addr uint16) Instruction {
+    return DisassembleNamed(mem, addr, nil)
+}
+
+// Like Disassemble, but jump targets and addresses that namer knows are
+// written as labels. A nil namer names nothing.
+func DisassembleNamed(mem Reader, addr uint16, namer Namer) (instruction Instruction) {
+    instruction.Addr = addr
+
+    opCode := mem.Read(addr)
+
+    if opCode == 0xCB {
+        return disassembleCb(mem, addr)
+    }
+
+    info := opTable[opCode]
+    if info.text == "" {
+        instruction.Bytes = []byte{opCode}
+        instruction.Text = fmt.Sprintf("db $%.2x", opCode)
+        instruction.Length = 1
+        return
+    }
+
+    instruction.Valid = true
+    instruction.Length = info.length
+    instruction.Cycles = info.cycles
+    instruction.CyclesTaken = info.cycles
+    if info.cyclesTaken != 0 {
+        instruction.CyclesTaken = info.cyclesTaken
+    }
+
+    instruction.Bytes = make([]byte, info.length)
+    for i := range instruction.Bytes {
+        instruction.Bytes[i] = mem.Read(addr + uint16(i))
+    }
+
+    text := info.text
+    next := addr + uint16(info.length)
+
+    switch {
+    case strings.Contains(text, "n16"), strings.Contains(text, "a16"):
+        word := uint16(instruction.Bytes[2])<<8 | uint16(instruction.Bytes[1])
+        text = strings.NewReplacer("n16", fmt.Sprintf("$%.4x", word), "a16", addrOperand(namer, word)).Replace(text)
+        if strings.HasPrefix(text, "jp") || strings.HasPrefix(text, "call") {
+            instruction.Target, instruction.HasTarget = word, true
+        }
+    case strings.Contains(text, "n8"):
+        text = strings.Replace(text, "n8", fmt.Sprintf("$%.2x", instruction.Bytes[1]), 1)
+    case strings.Contains(text, "a8"):
+        text = strings.Replace(text, "a8", addrOperand(namer, 0xFF00|uint16(instruction.Bytes[1])), 1)
+    case strings.Contains(text, "e8"):
+        target := next + uint16(int8(instruction.Bytes[1]))
+        text = strings.Replace(text, "e8", addrOperand(namer, target), 1)
+        instruction.Target, instruction.HasTarget = target, true
+    case strings.Contains(text, "s8"):
+        text = strings.Replace(text, "s8", fmt.Sprintf("%d", int8(instruction.Bytes[1])), 1)
+        text = strings.Replace(text, "+-", "-", 1)
+    case strings.HasPrefix(text, "rst"):
+        instruction.Target, instruction.HasTarget = uint16(opCode&0x38), true
+    }
+
+    instruction.Text = text
+    return
+}
+
+func addrOperand(namer Namer, addr uint16) string {
+    if namer != nil {
+        if name, ok := namer.Name(addr); ok {
+            return name
+        }
+    }
+
+    return fmt.Sprintf("$%.4x", addr)
+}
+
+func disassembleCb(mem Reader, addr uint16) (instruction Instruction) {
+    opCode := mem.Read(addr + 1)
+    reg := cbRegs[opCode&0x07]
+
+    instruction.Addr = addr
+    instruction.Bytes = []byte{0xCB, opCode}
+    instruction.Length = 2
+    instruction.Valid = true
+
+    switch opCode >> 6 {
+    case 0:
+        instruction.Text = cbOps[opCode>>3] + " " + reg
+    case 1:
+        instruction.Text = fmt.Sprintf("bit %d, %s", (opCode>>3)&0x07, reg)
+    case 2:
+        instruction.Text = fmt.Sprintf("res %d, %s", (opCode>>3)&0x07, reg)
+    case 3:
+        instruction.Text = fmt.Sprintf("set %d, %s", (opCode>>3)&0x07, reg)
+    }
+
+    instruction.Cycles = 8
+    if reg == "[hl]" {
+        if opCode>>6 == 1 {
+            instruction.Cycles = 12
+        } else {
+            instruction.Cycles = 16
+        }
+    }
+
+    instruction.CyclesTaken = instruction.Cycles
+    return
+}
+
+// Hex bytes of the instruction, e.g. "cd 50 01"
+func (instruction Instruction) HexBytes() string {
+    hex := make([]string, len(instruction.Bytes))
+    for i, val := range instruction.Bytes {
+        hex[i] = fmt.Sprintf("%.2x", val)
+    }
+
+    return strings.Join(hex, " ")
+}
+
+func (instruction Instruction) String() string {
+    return fmt.Sprintf("$%.4x: %-8s  %s", instruction.Addr, instruction.HexBytes(), instruction.Text)
+}
@@ -0,0 +1,168 @@
+package gameboy
+
+import (
+    . "../clock"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "regexp"
+    "strings"
+    "testing"
+)
+
+// Blargg's test ROMs aren't distributed with the emulator. Copy each suite's
+// directory from the test ROM archive into testdata/blargg, e.g.
+//
+//     testdata/blargg/cpu_instrs/cpu_instrs.gb
+//     testdata/blargg/cpu_instrs/individual/01-special.gb
+//     testdata/blargg/halt_bug.gb
+//
+// and any suite that isn't there is skipped.
+var blarggSuites = []string{"cpu_instrs", "instr_timing", "mem_timing", "halt_bug"}
+
+const (
+    blarggDir = "testdata/blargg"
+    // Emulated time before a ROM that hasn't reported a result is failed.
+    // The full cpu_instrs takes the longest, at under a minute.
+    blarggTimeout = 120 * CyclesPerSecond
+
+    // Result memory in cartridge RAM: a status byte, a signature and then
+    // zero terminated text
+    blarggStatus    = 0xA000
+    blarggSignature = 0xA001
+    blarggText      = 0xA004
+    blarggRunning   = 0x80
+)
+
+// Results like "01:ok" or "02:01" that the combined ROMs print for each of
+// the individual tests they run
+var blarggSubtest = regexp.MustCompile(`\b(\d\d):(ok|\d+)`)
+
+func TestBlargg(t *testing.T) {
+    for _, suite := range blarggSuites {
+        t.Run(suite, func(t *testing.T) {
+            roms := findRoms(filepath.Join(blarggDir, suite))
+            if len(roms) == 0 {
+                t.Skip("no ROMs in " + filepath.Join(blarggDir, suite))
+            }
+
+            for _, rom := range roms {
+                name := strings.TrimSuffix(filepath.Base(rom), filepath.Ext(rom))
+                t.Run(name, func(t *testing.T) {
+                    runBlargg(t, rom)
+                })
+            }
+        })
+    }
+}
+
+// The .gb files in dir and everything under it, or dir.gb if it's a
+// single ROM
+func findRoms(dir string) (roms []string) {
+    if _, err := os.Stat(dir + ".gb"); err == nil {
+        return []string{dir + ".gb"}
+    }
+
+    filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+        if err == nil && !info.IsDir() && strings.EqualFold(filepath.Ext(path), ".gb") {
+            roms = append(roms, path)
+        }
+        return nil
+    })
+
+    return
+}
+
+func loadTestRom(t *testing.T, path string, model Model) *GameBoy {
+    romData, err := ioutil.ReadFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    gameBoy := &GameBoy{}
+    gameBoy.SetModel(model)
+    gameBoy.Init(romData)
+
+    // Otherwise nothing is loaded and the test just times out running NOPs
+    if !gameBoy.Rom.Supported() {
+        t.Skipf("cartridge type %.2X (%s) isn't supported", gameBoy.Rom.CartridgeType(), gameBoy.Rom.CartridgeName())
+    }
+
+    gameBoy.SkipBoot()
+    return gameBoy
+}
+
+// Runs a ROM until it reports a result over the serial port or in
+// cartridge RAM, and fails the test if it didn't pass
+func runBlargg(t *testing.T, path string) {
+    gameBoy := loadTestRom(t, path, ModelAuto)
+
+    // There's no link cable, so every byte sent is just collected. The test
+    // ROMs start a transfer by writing 0x81 to SC after putting the byte in
+    // SB.
+    var serial strings.Builder
+    gameBoy.Ram.HookWrite(0xFF02, func(val byte) {
+        if val == 0x81 {
+            serial.WriteByte(gameBoy.Ram.Read(0xFF01))
+        }
+    })
+
+    var (
+        output string
+        passed bool
+        done   bool
+    )
+
+    for cycles := 0; cycles < blarggTimeout && !done; {
+        frame := gameBoy.Frames()
+
+        step := gameBoy.Step()
+        if step < 0 {
+            t.Fatalf("unknown opcode at $%.4x after %d cycles\n%s", gameBoy.Cpu.PC(), cycles, serial.String())
+        }
+        cycles += step
+
+        // Checking once a frame is plenty
+        if gameBoy.Frames() == frame {
+            continue
+        }
+
+        if status, text, ok := blarggMemory(gameBoy); ok && status != blarggRunning {
+            output, passed, done = text, status == 0, true
+        } else if text := serial.String(); strings.Contains(text, "Passed") || strings.Contains(text, "Failed") {
+            output, passed, done = text, !strings.Contains(text, "Failed"), true
+        }
+    }
+
+    if !done {
+        t.Fatalf("no result after %d seconds\n%s", blarggTimeout/CyclesPerSecond, serial.String())
+    }
+
+    for _, match := range blarggSubtest.FindAllStringSubmatch(output, -1) {
+        number, result := match[1], match[2]
+        t.Run(number, func(t *testing.T) {
+            if result != "ok" {
+                t.Errorf("failed with code %s", result)
+            }
+        })
+    }
+
+    if !passed {
+        t.Errorf("failed:\n%s", output)
+    }
+}
+
+func blarggMemory(gameBoy *GameBoy) (status byte, text string, ok bool) {
+    ram := &gameBoy.Ram
+
+    if ram.Read(blarggSignature) != 0xDE || ram.Read(blarggSignature+1) != 0xB0 || ram.Read(blarggSignature+2) != 0x61 {
+        return
+    }
+
+    var builder strings.Builder
+    for loc := uint16(blarggText); loc < 0xC000 && ram.Read(loc) != 0; loc++ {
+        builder.WriteByte(ram.Read(loc))
+    }
+
+    return ram.Read(blarggStatus), builder.String(), true
+}
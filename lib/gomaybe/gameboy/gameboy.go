@@ -0,0 +1,214 @@
+package gameboy
+
+import (
+    . "../cheats"
+    . "../clock"
+    . "../cpu"
+    . "../hdma"
+    . "../joypad"
+    . "../ppu"
+    . "../ram"
+    . "../rom"
+    . "../sgb"
+    . "../speed"
+    "hash/crc32"
+    "image"
+)
+
+type GameBoy struct {
+    Cpu         Cpu
+    Ram         Ram
+    Rom         Rom
+    Joypad      Joypad
+    Speed       Speed
+    Ppu         Ppu
+    Hdma        Hdma
+    Sgb         Sgb
+    Cheats      Cheats
+    romData     []byte
+    checksum    uint32
+    frames      uint64
+    frameCycles int
+    skipBoot    bool
+    model       Model
+    cgb         bool
+    sgb         bool
+    // Output colours, which stick across resets
+    dmgPalette     *DmgPalette
+    compatPalettes *CompatibilityPalettes
+    correction     Correction
+}
+
+func (gameBoy *GameBoy) Init(romData []byte) {
+    gameBoy.romData = romData
+    gameBoy.checksum = crc32.ChecksumIEEE(romData)
+    gameBoy.Cheats.Init(&gameBoy.Ram)
+    gameBoy.Reset()
+}
+
+// Powers the machine off and on again with the same ROM
+func (gameBoy *GameBoy) Reset() {
+    gameBoy.frames = 0
+    gameBoy.frameCycles = 0
+    gameBoy.cgb = gameBoy.model == ModelCGB || gameBoy.model == ModelAuto && cgbCartridge(gameBoy.romData)
+    gameBoy.sgb = gameBoy.model == ModelSGB || gameBoy.model == ModelAuto && !gameBoy.cgb && sgbCartridge(gameBoy.romData)
+    gameBoy.Ram.Init()
+    gameBoy.Rom.Init(gameBoy.romData, &gameBoy.Ram)
+    gameBoy.Joypad.Init(&gameBoy.Ram)
+    // A CGB runs DMG cartridges with the DMG's shades
+    gameBoy.Ppu.Init(&gameBoy.Ram, gameBoy.cgb && cgbCartridge(gameBoy.romData))
+    gameBoy.setColors()
+    gameBoy.Ppu.SetVBlankHandler(gameBoy.Cheats.VBlank)
+    gameBoy.Cpu.Init(&gameBoy.Ram)
+    gameBoy.Cpu.SetStopHandler(nil)
+
+    if gameBoy.cgb {
+        gameBoy.Ram.EnableBanks()
+        gameBoy.Speed.Init(&gameBoy.Ram)
+        gameBoy.Cpu.SetStopHandler(func() { gameBoy.Speed.Switch() })
+    }
+
+    // VRAM DMA only exists for CGB cartridges
+    if gameBoy.Ppu.Cgb() {
+        gameBoy.Hdma.Init(&gameBoy.Ram)
+        gameBoy.Ppu.SetHBlankHandler(gameBoy.Hdma.HBlank)
+    }
+
+    if gameBoy.sgb {
+        gameBoy.Sgb.Init(&gameBoy.Joypad, &gameBoy.Ppu)
+    }
+
+    // Only the DMG boot ROM is built in, so a CGB or SGB always starts at
+    // the cartridge
+    if gameBoy.skipBoot || gameBoy.cgb || gameBoy.sgb {
+        gameBoy.skipBootRegisters()
+    }
+}
+
+func (gameBoy *GameBoy) skipBootRegisters() {
+    gameBoy.Ram.SkipBoot()
+    gameBoy.Cpu.SkipBoot(gameBoy.cgb)
+
+    // What the SGB's boot ROM leaves behind
+    if gameBoy.sgb {
+        for name, val := range map[string]uint16{"af": 0x0100, "bc": 0x0014, "de": 0x0000, "hl": 0xC060} {
+            gameBoy.Cpu.SetRegister(name, val)
+        }
+    }
+}
+
+func (gameBoy *GameBoy) setColors() {
+    ppu := &gameBoy.Ppu
+    ppu.SetCorrection(gameBoy.correction)
+
+    switch {
+    case ppu.Cgb():
+    case gameBoy.cgb && gameBoy.compatPalettes != nil:
+        ppu.SetCompatibilityPalettes(*gameBoy.compatPalettes)
+    case gameBoy.cgb && gameBoy.dmgPalette != nil:
+        ppu.SetCompatibilityPalettes(DmgCompatibilityPalettes(*gameBoy.dmgPalette))
+    case gameBoy.cgb:
+        ppu.SetCompatibilityPalettes(LookupCompatibilityPalettes(gameBoy.romData))
+    case gameBoy.dmgPalette != nil:
+        ppu.SetDmgPalette(*gameBoy.dmgPalette)
+    case gameBoy.compatPalettes != nil:
+        ppu.SetDmgPalette(gameBoy.compatPalettes.Dmg())
+    }
+}
+
+// Colours DMG games with palette, on a CGB too in place of the palettes its
+// boot ROM would pick. Takes effect at the next reset.
+func (gameBoy *GameBoy) SetDmgPalette(palette DmgPalette) {
+    gameBoy.dmgPalette = &palette
+    gameBoy.compatPalettes = nil
+}
+
+// Colours DMG games on a CGB as if the palettes had been picked with buttons
+// at boot. A DMG uses the background colours. Takes effect at the next reset.
+func (gameBoy *GameBoy) SetCompatibilityPalettes(palettes CompatibilityPalettes) {
+    gameBoy.compatPalettes = &palettes
+    gameBoy.dmgPalette = nil
+}
+
+// How CGB colours are shown. Takes effect straight away.
+func (gameBoy *GameBoy) SetCorrection(correction Correction) {
+    gameBoy.correction = correction
+    gameBoy.Ppu.SetCorrection(correction)
+}
+
+// Starts the cartridge directly, as if the boot ROM had just finished. This
+// sticks across resets.
+func (gameBoy *GameBoy) SkipBoot() {
+    gameBoy.skipBoot = true
+    gameBoy.skipBootRegisters()
+}
+
+// Runs one instruction and returns how long it took in cycles of the
+// normal speed clock, which is what the LCD and sound run from. At double
+// speed that's half the CPU's own cycle count.
+func (gameBoy *GameBoy) Step() (cycles int) {
+    cycles = gameBoy.Cpu.Step()
+    if cycles < 0 {
+        return
+    }
+
+    if gameBoy.cgb && gameBoy.Speed.Double() {
+        cycles /= 2
+    }
+
+    // The CPU sits out any VRAM DMA it started
+    cycles += gameBoy.Hdma.Stall()
+
+    gameBoy.Ppu.Advance(gameBoy.frameCycles, cycles)
+    gameBoy.frameCycles += cycles
+    if gameBoy.frameCycles >= CyclesPerFrame {
+        gameBoy.frameCycles -= CyclesPerFrame
+        gameBoy.frames++
+        gameBoy.Ppu.RenderFrame()
+        if gameBoy.sgb {
+            gameBoy.Sgb.Frame()
+        }
+    }
+
+    return
+}
+
+// Which bank is mapped at addr, numbered the way RGBDS numbers them in
+// symbol files
+func (gameBoy *GameBoy) BankAt(addr uint16) int {
+    switch {
+    case addr >= 0x4000 && addr < 0x8000:
+        return gameBoy.Rom.Bank()
+    case addr >= 0x8000 && addr < 0xA000:
+        return gameBoy.Ram.VramBank()
+    case addr >= 0xD000 && addr < 0xE000:
+        return gameBoy.Ram.WramBank()
+    }
+
+    return 0
+}
+
+// What the LCD is showing
+func (gameBoy *GameBoy) Screen() *image.RGBA {
+    return gameBoy.Ppu.Screen()
+}
+
+// The whole picture to show, which on an SGB is the screen inside its
+// border
+func (gameBoy *GameBoy) Display() *image.RGBA {
+    if gameBoy.sgb {
+        return gameBoy.Sgb.Display()
+    }
+
+    return gameBoy.Screen()
+}
+
+// Number of frames completed since power on
+func (gameBoy *GameBoy) Frames() uint64 {
+    return gameBoy.frames
+}
+
+// CRC-32 of the loaded ROM image
+func (gameBoy *GameBoy) Checksum() uint32 {
+    return gameBoy.checksum
+}
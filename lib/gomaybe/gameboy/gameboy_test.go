@@ -0,0 +1,34 @@
+package gameboy
+
+import (
+    "testing"
+)
+
+// Instructions run for each input
+const fuzzSteps = 2000
+
+// Random ROM images run from the cartridge entry point
+func FuzzRun(f *testing.F) {
+    f.Add([]byte{})
+    f.Add(make([]byte, 0x0150))
+
+    jump := make([]byte, 0x0160)
+    copy(jump[0x0100:], []byte{0xC3, 0x50, 0x01})
+    f.Add(jump)
+
+    f.Fuzz(func(t *testing.T, romData []byte) {
+        var gameBoy GameBoy
+        gameBoy.Init(romData)
+        gameBoy.SkipBoot()
+
+        for i := 0; i < fuzzSteps; i++ {
+            if gameBoy.Step() < 0 {
+                return
+            }
+
+            if f, _ := gameBoy.Cpu.Register("f"); f&0x0F != 0 {
+                t.Fatalf("low bits of F set to $%.2x at $%.4x", f, gameBoy.Cpu.PC())
+            }
+        }
+    })
+}
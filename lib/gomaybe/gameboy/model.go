@@ -0,0 +1,78 @@
+package gameboy
+
+import (
+    "fmt"
+    "strings"
+)
+
+// Which hardware to emulate
+type Model int
+
+const (
+    // Picked from the cartridge header: a CGB for cartridges that support
+    // it, an SGB for those that support that and a DMG for everything else
+    ModelAuto Model = iota
+    ModelDMG
+    ModelCGB
+    ModelSGB
+)
+
+var modelNames = []string{"auto", "dmg", "cgb", "sgb"}
+
+const (
+    cgbFlagLoc     = 0x0143
+    sgbFlagLoc     = 0x0146
+    oldLicenseeLoc = 0x014B
+)
+
+func (model Model) String() string {
+    if model < 0 || int(model) >= len(modelNames) {
+        return fmt.Sprintf("model %d", int(model))
+    }
+
+    return modelNames[model]
+}
+
+func ParseModel(name string) (Model, error) {
+    for i, modelName := range modelNames {
+        if strings.EqualFold(name, modelName) {
+            return Model(i), nil
+        }
+    }
+
+    return ModelAuto, fmt.Errorf("unknown model %q, expected one of %s", name, strings.Join(modelNames, ", "))
+}
+
+// Bit 7 of the CGB flag is set for both CGB enhanced ($80) and CGB only
+// ($C0) cartridges
+func cgbCartridge(romData []byte) bool {
+    return len(romData) > cgbFlagLoc && romData[cgbFlagLoc]&0x80 != 0
+}
+
+// The SGB only listens to cartridges that set its flag and use the new
+// licensee code
+func sgbCartridge(romData []byte) bool {
+    return len(romData) > oldLicenseeLoc && romData[sgbFlagLoc] == 0x03 && romData[oldLicenseeLoc] == 0x33
+}
+
+// Forces a model instead of going by the cartridge header. Takes effect at
+// the next Init or Reset.
+func (gameBoy *GameBoy) SetModel(model Model) {
+    gameBoy.model = model
+}
+
+// The model actually being emulated, never ModelAuto
+func (gameBoy *GameBoy) Model() Model {
+    switch {
+    case gameBoy.cgb:
+        return ModelCGB
+    case gameBoy.sgb:
+        return ModelSGB
+    }
+
+    return ModelDMG
+}
+
+func (gameBoy *GameBoy) Cgb() bool {
+    return gameBoy.cgb
+}
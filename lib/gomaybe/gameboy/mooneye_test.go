@@ -0,0 +1,147 @@
+package gameboy
+
+import (
+    . "../clock"
+    "path/filepath"
+    "regexp"
+    "strings"
+    "testing"
+)
+
+// The Mooneye test suite isn't distributed with the emulator either. Copy
+// the acceptance and emulator-only directories of a build into
+// testdata/mooneye, e.g.
+//
+//     testdata/mooneye/acceptance/add_sp_e_timing.gb
+//     testdata/mooneye/emulator-only/mbc1/bits_bank1.gb
+var mooneyeDirs = []string{"acceptance", "emulator-only"}
+
+const (
+    mooneyeDir = "testdata/mooneye"
+    // Every test finishes in well under this much emulated time
+    mooneyeTimeout = 20 * CyclesPerSecond
+)
+
+// The MGB is a DMG apart from what its boot ROM leaves in A, which is how
+// the tests tell them apart
+type mooneyeModel struct {
+    name  string
+    model Model
+    regs  map[string]uint16
+}
+
+var mooneyeModels = []mooneyeModel{
+    {"dmg", ModelDMG, nil},
+    {"mgb", ModelDMG, map[string]uint16{"a": 0xFF}},
+    {"cgb", ModelCGB, nil},
+    {"sgb", ModelSGB, nil},
+}
+
+// Tests that only pass on some models say which ones at the end of their
+// name, e.g. boot_regs-dmgABC or di_timing-GS, where G is every DMG and MGB,
+// S is every SGB, C is every CGB and A is every AGB
+var mooneyeModelTags = regexp.MustCompile(`dmg0|dmgABC|mgb|sgb2|sgb|cgb0|cgbABCDE|agb0|agbA|ags|G|S|C|A`)
+
+var mooneyeTagModels = map[string][]string{
+    "dmgABC":   {"dmg"},
+    "mgb":      {"mgb"},
+    "G":        {"dmg", "mgb"},
+    "sgb":      {"sgb"},
+    "S":        {"sgb"},
+    "cgbABCDE": {"cgb"},
+    "C":        {"cgb"},
+}
+
+// Subtests are named model/dir/test, so go test -run Mooneye/cgb only runs
+// the tests for the CGB
+func TestMooneye(t *testing.T) {
+    for _, model := range mooneyeModels {
+        t.Run(model.name, func(t *testing.T) {
+            var roms []string
+            for _, dir := range mooneyeDirs {
+                roms = append(roms, findRoms(filepath.Join(mooneyeDir, dir))...)
+            }
+
+            if len(roms) == 0 {
+                t.Skip("no ROMs in " + mooneyeDir)
+            }
+
+            for _, rom := range roms {
+                if !mooneyeSupports(rom, model.name) {
+                    continue
+                }
+
+                name, _ := filepath.Rel(mooneyeDir, strings.TrimSuffix(rom, filepath.Ext(rom)))
+                t.Run(filepath.ToSlash(name), func(t *testing.T) {
+                    runMooneye(t, rom, model)
+                })
+            }
+        })
+    }
+}
+
+func mooneyeSupports(path string, model string) bool {
+    name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+    dash := strings.LastIndex(name, "-")
+    if dash < 0 {
+        return true
+    }
+
+    suffix := name[dash+1:]
+    tags := mooneyeModelTags.FindAllString(suffix, -1)
+
+    // Anything else after a dash is part of the name
+    if strings.Join(tags, "") != suffix {
+        return true
+    }
+
+    for _, tag := range tags {
+        for _, supported := range mooneyeTagModels[tag] {
+            if supported == model {
+                return true
+            }
+        }
+    }
+
+    return false
+}
+
+// Runs a ROM until it hits LD B,B. Passing tests leave the start of the
+// Fibonacci sequence in B, C, D, E, H and L and failing ones leave 0x42 in
+// all of them.
+func runMooneye(t *testing.T, path string, model mooneyeModel) {
+    gameBoy := loadTestRom(t, path, model.model)
+
+    for name, val := range model.regs {
+        gameBoy.Cpu.SetRegister(name, val)
+    }
+
+    done := false
+    gameBoy.Cpu.SetBreakpointHandler(func() {
+        done = true
+    })
+
+    for cycles := 0; !done; {
+        if cycles >= mooneyeTimeout {
+            t.Fatalf("no result after %d seconds", mooneyeTimeout/CyclesPerSecond)
+        }
+
+        step := gameBoy.Step()
+        if step < 0 {
+            t.Fatalf("unknown opcode at $%.4x after %d cycles", gameBoy.Cpu.PC(), cycles)
+        }
+        cycles += step
+    }
+
+    fibonacci := []uint16{3, 5, 8, 13, 21, 34}
+
+    for i, name := range []string{"b", "c", "d", "e", "h", "l"} {
+        if val, _ := gameBoy.Cpu.Register(name); val != fibonacci[i] {
+            bc, _ := gameBoy.Cpu.Register("bc")
+            de, _ := gameBoy.Cpu.Register("de")
+            hl, _ := gameBoy.Cpu.Register("hl")
+            t.Fatalf("failed with BC=$%.4x DE=$%.4x HL=$%.4x at $%.4x", bc, de, hl, gameBoy.Cpu.PC())
+        }
+    }
+}
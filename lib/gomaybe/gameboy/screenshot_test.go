@@ -0,0 +1,206 @@
+package gameboy
+
+import (
+    "image"
+    "image/color"
+    "image/png"
+    "os"
+    "path/filepath"
+    "sort"
+    "testing"
+)
+
+// Screenshot tests run a ROM and compare the screen against a reference
+// image. The ROMs and references aren't distributed with the emulator, so
+// copy them into testdata/screenshots, named after the test:
+//
+//     testdata/screenshots/dmg-acid2.gb
+//     testdata/screenshots/dmg-acid2.png
+//
+// Failures write the screen and a diff to testdata/failed.
+type screenshotTest struct {
+    name string
+    // Frames to run for unless the ROM hits LD B,B first
+    frames int
+    // CGB screens are compared colour for colour, DMG ones by shade
+    model Model
+}
+
+var screenshotTests = []screenshotTest{
+    {"dmg-acid2", 600, ModelDMG},
+    {"cgb-acid2", 600, ModelCGB},
+}
+
+const (
+    screenshotDir = "testdata/screenshots"
+    failedDir     = "testdata/failed"
+)
+
+func TestScreenshots(t *testing.T) {
+    for _, test := range screenshotTests {
+        t.Run(test.name, func(t *testing.T) {
+            rom := filepath.Join(screenshotDir, test.name+".gb")
+            reference := filepath.Join(screenshotDir, test.name+".png")
+
+            for _, path := range []string{rom, reference} {
+                if _, err := os.Stat(path); err != nil {
+                    t.Skip("no " + path)
+                }
+            }
+
+            gameBoy := loadTestRom(t, rom, test.model)
+            runFrames(t, gameBoy, test.frames)
+            compareScreenshot(t, test.name, gameBoy.Screen(), reference, gameBoy.Cgb())
+        })
+    }
+}
+
+// Runs until the given number of frames have been drawn or the ROM executes
+// LD B,B
+func runFrames(t *testing.T, gameBoy *GameBoy, frames int) {
+    done := false
+    gameBoy.Cpu.SetBreakpointHandler(func() {
+        done = true
+    })
+    defer gameBoy.Cpu.SetBreakpointHandler(nil)
+
+    for !done && gameBoy.Frames() < uint64(frames) {
+        if gameBoy.Step() < 0 {
+            t.Fatalf("unknown opcode at $%.4x in frame %d", gameBoy.Cpu.PC(), gameBoy.Frames())
+        }
+    }
+}
+
+// Fails the test if screen doesn't match the PNG at referencePath, after
+// both have been reduced to what the hardware could have meant by them
+func compareScreenshot(t *testing.T, name string, screen image.Image, referencePath string, cgb bool) {
+    reference, err := loadPng(referencePath)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if screen.Bounds().Size() != reference.Bounds().Size() {
+        t.Fatalf("screen is %v but %s is %v", screen.Bounds().Size(), referencePath, reference.Bounds().Size())
+    }
+
+    got := normalise(screen, cgb)
+    want := normalise(reference, cgb)
+
+    size := screen.Bounds().Size()
+    diff := image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+    mismatches := 0
+
+    for y := 0; y < size.Y; y++ {
+        for x := 0; x < size.X; x++ {
+            i := y*size.X + x
+
+            if got[i] != want[i] {
+                diff.Set(x, y, color.RGBA{0xFF, 0x00, 0x00, 0xFF})
+                mismatches++
+            } else {
+                // Matching pixels are faded so the differences stand out
+                gray := color.GrayModel.Convert(reference.At(reference.Bounds().Min.X+x, reference.Bounds().Min.Y+y)).(color.Gray)
+                diff.Set(x, y, color.Gray{0xC0 + gray.Y/4})
+            }
+        }
+    }
+
+    if mismatches == 0 {
+        return
+    }
+
+    if err := os.MkdirAll(failedDir, 0755); err != nil {
+        t.Fatal(err)
+    }
+
+    screenPath := filepath.Join(failedDir, name+".png")
+    diffPath := filepath.Join(failedDir, name+"-diff.png")
+
+    for path, img := range map[string]image.Image{screenPath: screen, diffPath: diff} {
+        if err := savePng(path, img); err != nil {
+            t.Error(err)
+        }
+    }
+
+    t.Errorf("%d pixels differ from %s, see %s and %s", mismatches, referencePath, screenPath, diffPath)
+}
+
+// Turns every pixel into something comparable between emulators. Colours
+// are cut down to the 15 bits the CGB has. DMG shades depend on the palette
+// each emulator picked, so they're numbered from lightest to darkest.
+func normalise(img image.Image, cgb bool) []int {
+    bounds := img.Bounds()
+    pixels := make([]int, 0, bounds.Dx()*bounds.Dy())
+
+    for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+        for x := bounds.Min.X; x < bounds.Max.X; x++ {
+            r, g, b, _ := img.At(x, y).RGBA()
+            pixels = append(pixels, int(r>>11)<<10|int(g>>11)<<5|int(b>>11))
+        }
+    }
+
+    if !cgb {
+        shades := shadeMap(pixels)
+        for i, pixel := range pixels {
+            pixels[i] = shades[pixel]
+        }
+    }
+
+    return pixels
+}
+
+// Numbers the colours in a DMG image from 0 for the lightest to 3 for the
+// darkest. With all four shades on screen they're ranked, which works for
+// any palette. With fewer there's no telling which are missing, so they're
+// assumed to be shades of gray and bucketed by brightness.
+func shadeMap(pixels []int) map[int]int {
+    shades := make(map[int]int)
+    for _, pixel := range pixels {
+        shades[pixel] = 0
+    }
+
+    luma := func(pixel int) int {
+        return 299*(pixel>>10&0x1F) + 587*(pixel>>5&0x1F) + 114*(pixel&0x1F)
+    }
+
+    colors := make([]int, 0, len(shades))
+    for pixel := range shades {
+        colors = append(colors, pixel)
+    }
+    sort.Slice(colors, func(i, j int) bool { return luma(colors[i]) > luma(colors[j]) })
+
+    for rank, pixel := range colors {
+        if len(colors) == 4 {
+            shades[pixel] = rank
+        } else {
+            // luma runs from 0 to 31000
+            shades[pixel] = 3 - (luma(pixel)+5166)/10333
+        }
+    }
+
+    return shades
+}
+
+func loadPng(path string) (image.Image, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    return png.Decode(file)
+}
+
+func savePng(path string, img image.Image) error {
+    file, err := os.Create(path)
+    if err != nil {
+        return err
+    }
+
+    if err := png.Encode(file, img); err != nil {
+        file.Close()
+        return err
+    }
+
+    return file.Close()
+}
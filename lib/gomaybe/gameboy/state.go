@@ -0,0 +1,231 @@
+package gameboy
+
+import (
+    "bytes"
+    "crypto/sha1"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "strings"
+    "time"
+)
+
+// Save states start with a header:
+//
+//     magic     "GMBS"
+//     version   uint16
+//     checksum  uint32, CRC-32 of the ROM the state was taken from
+//     timestamp int64, Unix seconds
+//
+// followed by one chunk per component, each a four byte tag, a uint32 length
+// and that many bytes of data. All integers are little endian.
+const (
+    stateMagic   = "GMBS"
+    StateVersion = 7
+)
+
+type StateHeader struct {
+    Version  uint16
+    Checksum uint32
+    Time     time.Time
+}
+
+type stateChunk struct {
+    tag  string
+    save func(w io.Writer) error
+    load func(r io.Reader) error
+}
+
+// Every component with state gets a chunk here
+func (gameBoy *GameBoy) stateChunks() []stateChunk {
+    return []stateChunk{
+        {"SYS ", gameBoy.saveSystem, gameBoy.loadSystem},
+        {"CPU ", gameBoy.Cpu.SaveState, gameBoy.Cpu.LoadState},
+        {"RAM ", gameBoy.Ram.SaveState, gameBoy.Ram.LoadState},
+        {"BANK", gameBoy.Ram.SaveBanks, gameBoy.Ram.LoadBanks},
+        {"JOYP", gameBoy.Joypad.SaveState, gameBoy.Joypad.LoadState},
+        {"KEY1", gameBoy.Speed.SaveState, gameBoy.Speed.LoadState},
+        {"PAL ", gameBoy.Ppu.SaveState, gameBoy.Ppu.LoadState},
+        {"HDMA", gameBoy.Hdma.SaveState, gameBoy.Hdma.LoadState},
+        {"SGB ", gameBoy.Sgb.SaveState, gameBoy.Sgb.LoadState},
+    }
+}
+
+func (gameBoy *GameBoy) saveSystem(w io.Writer) error {
+    state := make([]byte, 13)
+    binary.LittleEndian.PutUint64(state, gameBoy.frames)
+    binary.LittleEndian.PutUint32(state[8:], uint32(gameBoy.frameCycles))
+    state[12] = byte(gameBoy.Model())
+
+    _, err := w.Write(state)
+    return err
+}
+
+func (gameBoy *GameBoy) loadSystem(r io.Reader) error {
+    state := make([]byte, 13)
+    if _, err := io.ReadFull(r, state); err != nil {
+        return err
+    }
+
+    if model := Model(state[12]); model != gameBoy.Model() {
+        return fmt.Errorf("save state is for a %s, running a %s", strings.ToUpper(model.String()), strings.ToUpper(gameBoy.Model().String()))
+    }
+
+    gameBoy.frames = binary.LittleEndian.Uint64(state)
+    gameBoy.frameCycles = int(binary.LittleEndian.Uint32(state[8:]))
+    return nil
+}
+
+func (gameBoy *GameBoy) SaveState(w io.Writer) error {
+    header := make([]byte, len(stateMagic)+14)
+    copy(header, stateMagic)
+    binary.LittleEndian.PutUint16(header[4:], StateVersion)
+    binary.LittleEndian.PutUint32(header[6:], gameBoy.checksum)
+    binary.LittleEndian.PutUint64(header[10:], uint64(time.Now().Unix()))
+
+    if _, err := w.Write(header); err != nil {
+        return err
+    }
+
+    return gameBoy.writeChunks(w)
+}
+
+// SHA-1 of the machine state, for checking that two runs ended up in the
+// same place
+func (gameBoy *GameBoy) Hash() []byte {
+    hash := sha1.New()
+    gameBoy.writeChunks(hash)
+    return hash.Sum(nil)
+}
+
+func (gameBoy *GameBoy) writeChunks(w io.Writer) error {
+    var data bytes.Buffer
+
+    for _, chunk := range gameBoy.stateChunks() {
+        data.Reset()
+        if err := chunk.save(&data); err != nil {
+            return err
+        }
+
+        chunkHeader := make([]byte, 8)
+        copy(chunkHeader, chunk.tag)
+        binary.LittleEndian.PutUint32(chunkHeader[4:], uint32(data.Len()))
+
+        if _, err := w.Write(chunkHeader); err != nil {
+            return err
+        }
+
+        if _, err := w.Write(data.Bytes()); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// No chunk comes anywhere near this, so anything bigger is a corrupt file
+// rather than something to allocate
+const maxChunkSize = 1 << 20
+
+// A whole save state is well under the limit for one chunk too, which is
+// enough to catch nonsense sizes where states are kept inside other files
+const MaxStateSize = maxChunkSize
+
+func (gameBoy *GameBoy) LoadState(r io.Reader) error {
+    header, err := ReadStateHeader(r)
+    if err != nil {
+        return err
+    }
+
+    if header.Checksum != gameBoy.checksum {
+        return fmt.Errorf("save state is for a different ROM (checksum %.8X, loaded %.8X)", header.Checksum, gameBoy.checksum)
+    }
+
+    data, err := readChunks(r)
+    if err != nil {
+        return err
+    }
+
+    for _, chunk := range gameBoy.stateChunks() {
+        if _, ok := data[chunk.tag]; !ok {
+            return fmt.Errorf("save state is missing %q", chunk.tag)
+        }
+    }
+
+    // A chunk can still turn out to be bad after the ones before it have
+    // been loaded, so keep the current state to put back if that happens
+    var backup bytes.Buffer
+    if err := gameBoy.writeChunks(&backup); err != nil {
+        return err
+    }
+
+    if err := gameBoy.loadChunks(data); err != nil {
+        previous, _ := readChunks(&backup)
+        gameBoy.loadChunks(previous)
+        return err
+    }
+
+    return nil
+}
+
+// Reads chunks up to the end of r, keyed by tag
+func readChunks(r io.Reader) (map[string][]byte, error) {
+    data := make(map[string][]byte)
+
+    for {
+        chunkHeader := make([]byte, 8)
+        if _, err := io.ReadFull(r, chunkHeader); err == io.EOF {
+            return data, nil
+        } else if err != nil {
+            return nil, err
+        }
+
+        tag := string(chunkHeader[:4])
+        size := binary.LittleEndian.Uint32(chunkHeader[4:])
+        if size > maxChunkSize {
+            return nil, fmt.Errorf("save state chunk %q is too big (%d bytes)", tag, size)
+        }
+
+        chunkData := make([]byte, size)
+        if _, err := io.ReadFull(r, chunkData); err != nil {
+            return nil, err
+        }
+
+        data[tag] = chunkData
+    }
+}
+
+func (gameBoy *GameBoy) loadChunks(data map[string][]byte) error {
+    for _, chunk := range gameBoy.stateChunks() {
+        if err := chunk.load(bytes.NewReader(data[chunk.tag])); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+func ReadStateHeader(r io.Reader) (header StateHeader, err error) {
+    raw := make([]byte, len(stateMagic)+14)
+    if _, err = io.ReadFull(r, raw); err != nil {
+        return
+    }
+
+    if string(raw[:4]) != stateMagic {
+        err = errors.New("not a save state")
+        return
+    }
+
+    header.Version = binary.LittleEndian.Uint16(raw[4:])
+    header.Checksum = binary.LittleEndian.Uint32(raw[6:])
+    header.Time = time.Unix(int64(binary.LittleEndian.Uint64(raw[10:])), 0)
+
+    // Chunks have been added and resized at every version with nothing to
+    // convert old ones, so only the current version can be loaded
+    if header.Version != StateVersion {
+        err = fmt.Errorf("save state version %d is not supported, only version %d", header.Version, StateVersion)
+    }
+
+    return
+}
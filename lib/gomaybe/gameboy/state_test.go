@@ -0,0 +1,90 @@
+package gameboy
+
+import (
+    "bytes"
+    "encoding/binary"
+    "strings"
+    "testing"
+)
+
+func stateGameBoy(t *testing.T) *GameBoy {
+    var gameBoy GameBoy
+    gameBoy.Init(make([]byte, 0x8000))
+    gameBoy.SkipBoot()
+
+    for i := 0; i < 1000; i++ {
+        gameBoy.Step()
+    }
+
+    return &gameBoy
+}
+
+// Rebuilds a save state with the chunk tagged tag replaced by data
+func replaceChunk(state []byte, tag string, data []byte) []byte {
+    headerSize := len(stateMagic) + 14
+    out := append([]byte(nil), state[:headerSize]...)
+
+    for pos := headerSize; pos < len(state); {
+        size := int(binary.LittleEndian.Uint32(state[pos+4:]))
+        chunk := state[pos : pos+8+size]
+        pos += 8 + size
+
+        if string(chunk[:4]) == tag {
+            chunkHeader := make([]byte, 8)
+            copy(chunkHeader, tag)
+            binary.LittleEndian.PutUint32(chunkHeader[4:], uint32(len(data)))
+            chunk = append(chunkHeader, data...)
+        }
+
+        out = append(out, chunk...)
+    }
+
+    return out
+}
+
+func TestLoadStateBadChunk(t *testing.T) {
+    gameBoy := stateGameBoy(t)
+
+    var state bytes.Buffer
+    if err := gameBoy.SaveState(&state); err != nil {
+        t.Fatal(err)
+    }
+
+    for i := 0; i < 1000; i++ {
+        gameBoy.Step()
+    }
+    hash := gameBoy.Hash()
+
+    // JOYP comes after the CPU and RAM, which mustn't be left loaded
+    if err := gameBoy.LoadState(bytes.NewReader(replaceChunk(state.Bytes(), "JOYP", []byte{0}))); err == nil {
+        t.Fatal("short JOYP chunk loaded")
+    }
+
+    if !bytes.Equal(gameBoy.Hash(), hash) {
+        t.Error("failed load changed the machine")
+    }
+
+    huge := replaceChunk(state.Bytes(), "SYS ", nil)
+    binary.LittleEndian.PutUint32(huge[len(stateMagic)+14+4:], 0xFFFFFFFF)
+    if err := gameBoy.LoadState(bytes.NewReader(huge)); err == nil || !strings.Contains(err.Error(), "too big") {
+        t.Errorf("huge chunk gave %v", err)
+    }
+
+    if err := gameBoy.LoadState(bytes.NewReader(state.Bytes())); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestReadStateHeaderVersion(t *testing.T) {
+    var state bytes.Buffer
+    if err := stateGameBoy(t).SaveState(&state); err != nil {
+        t.Fatal(err)
+    }
+
+    old := state.Bytes()
+    binary.LittleEndian.PutUint16(old[4:], StateVersion-1)
+
+    if _, err := ReadStateHeader(bytes.NewReader(old)); err == nil || !strings.Contains(err.Error(), "not supported") {
+        t.Errorf("old version gave %v", err)
+    }
+}
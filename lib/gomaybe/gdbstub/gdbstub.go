@@ -0,0 +1,414 @@
+package gdbstub
+
+import (
+    . "../gameboy"
+    "bufio"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "strconv"
+    "strings"
+)
+
+// GDB has no idea what an SM83 is, so the register file is described to it
+// with a target description. The register numbers here are the ones used by
+// the g, G, p and P packets.
+var registers = []string{"af", "bc", "de", "hl", "sp", "pc"}
+
+const targetXml = `<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target version="1.0">
+  <feature name="org.gomaybe.sm83">
+    <reg name="af" bitsize="16" type="int" regnum="0"/>
+    <reg name="bc" bitsize="16" type="int"/>
+    <reg name="de" bitsize="16" type="int"/>
+    <reg name="hl" bitsize="16" type="int"/>
+    <reg name="sp" bitsize="16" type="data_ptr"/>
+    <reg name="pc" bitsize="16" type="code_ptr"/>
+  </feature>
+</target>
+`
+
+const (
+    sigInt  = 2
+    sigTrap = 5
+    sigIll  = 4
+    // How often to look for an interrupt from the debugger while continuing
+    interruptCheck = 0x1000
+    // Largest memory read or write accepted in one packet
+    maxTransfer = 0x1000
+)
+
+// Something sent by the debugger: either a packet or a Ctrl-C
+type event struct {
+    packet    string
+    interrupt bool
+    // The packet's checksum didn't match, so it needs sending again
+    corrupt bool
+}
+
+// A GDB remote serial protocol server driving the machine
+type Stub struct {
+    gameBoy     *GameBoy
+    out         *bufio.Writer
+    events      chan event
+    // Packets that arrived while the target was running, to handle once it
+    // stops
+    pending     []event
+    breakpoints map[uint16]bool
+    noAck       bool
+}
+
+func (stub *Stub) Init(gameBoy *GameBoy) {
+    stub.gameBoy = gameBoy
+    stub.breakpoints = make(map[uint16]bool)
+}
+
+// Waits for a debugger to connect to addr, which should normally be on
+// localhost, and serves it until it detaches
+func (stub *Stub) ListenAndServe(addr string) error {
+    listener, err := net.Listen("tcp", addr)
+    if err != nil {
+        return err
+    }
+    defer listener.Close()
+
+    fmt.Println("Waiting for GDB on " + listener.Addr().String())
+
+    conn, err := listener.Accept()
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+
+    fmt.Println("GDB connected from " + conn.RemoteAddr().String())
+    return stub.Serve(conn)
+}
+
+func (stub *Stub) Serve(conn io.ReadWriter) error {
+    stub.out = bufio.NewWriter(conn)
+    stub.events = make(chan event)
+    stub.pending = nil
+    stub.noAck = false
+
+    go readEvents(bufio.NewReader(conn), stub.events)
+
+    for {
+        event, ok := stub.nextEvent()
+        if !ok {
+            break
+        }
+
+        // A Ctrl-C that arrives after the target already stopped has
+        // nothing left to interrupt
+        if event.interrupt {
+            continue
+        }
+
+        if !stub.noAck {
+            if event.corrupt {
+                stub.out.WriteByte('-')
+                if err := stub.out.Flush(); err != nil {
+                    return err
+                }
+                continue
+            }
+
+            stub.out.WriteByte('+')
+        }
+
+        reply, done := stub.handle(event.packet)
+        if reply != nil {
+            stub.reply(*reply)
+        }
+
+        if err := stub.out.Flush(); err != nil {
+            return err
+        }
+
+        if done {
+            return nil
+        }
+    }
+
+    return nil
+}
+
+// The oldest packet held back while the target was running, or else the
+// next thing from the debugger
+func (stub *Stub) nextEvent() (event, bool) {
+    if len(stub.pending) > 0 {
+        event := stub.pending[0]
+        stub.pending = stub.pending[1:]
+        return event, true
+    }
+
+    event, ok := <-stub.events
+    return event, ok
+}
+
+func readEvents(in *bufio.Reader, events chan event) {
+    defer close(events)
+
+    for {
+        b, err := in.ReadByte()
+        if err != nil {
+            return
+        }
+
+        switch b {
+        case 0x03:
+            events <- event{interrupt: true}
+        case '$':
+            data, err := in.ReadString('#')
+            if err != nil {
+                return
+            }
+
+            checksum := make([]byte, 2)
+            if _, err := io.ReadFull(in, checksum); err != nil {
+                return
+            }
+
+            data = strings.TrimSuffix(data, "#")
+            sum, err := strconv.ParseUint(string(checksum), 16, 8)
+            events <- event{packet: data, corrupt: err != nil || byte(sum) != packetSum(data)}
+        }
+    }
+}
+
+func packetSum(data string) (sum byte) {
+    for i := 0; i < len(data); i++ {
+        sum += data[i]
+    }
+
+    return
+}
+
+func (stub *Stub) reply(data string) {
+    fmt.Fprintf(stub.out, "$%s#%.2x", data, packetSum(data))
+}
+
+// Returns the reply to send, nil for none, and whether the session is over
+func (stub *Stub) handle(packet string) (*string, bool) {
+    reply := func(data string) (*string, bool) {
+        return &data, false
+    }
+
+    if packet == "" {
+        return reply("")
+    }
+
+    cpu := &stub.gameBoy.Cpu
+    args := packet[1:]
+
+    switch packet[0] {
+    case '?':
+        return reply(fmt.Sprintf("S%.2x", sigTrap))
+    case 'g':
+        var regs strings.Builder
+        for _, name := range registers {
+            val, _ := cpu.Register(name)
+            fmt.Fprintf(&regs, "%.2x%.2x", val&0xFF, val>>8)
+        }
+        return reply(regs.String())
+    case 'G':
+        data, err := hex.DecodeString(args)
+        if err != nil || len(data) < len(registers)*2 {
+            return reply("E01")
+        }
+        for i, name := range registers {
+            cpu.SetRegister(name, uint16(data[i*2])|uint16(data[i*2+1])<<8)
+        }
+        return reply("OK")
+    case 'p':
+        n, err := strconv.ParseUint(args, 16, 8)
+        if err != nil || int(n) >= len(registers) {
+            return reply("E01")
+        }
+        val, _ := cpu.Register(registers[n])
+        return reply(fmt.Sprintf("%.2x%.2x", val&0xFF, val>>8))
+    case 'P':
+        parts := strings.SplitN(args, "=", 2)
+        n, err := strconv.ParseUint(parts[0], 16, 8)
+        if err != nil || int(n) >= len(registers) || len(parts) < 2 {
+            return reply("E01")
+        }
+        data, err := hex.DecodeString(parts[1])
+        if err != nil || len(data) < 2 {
+            return reply("E01")
+        }
+        cpu.SetRegister(registers[n], uint16(data[0])|uint16(data[1])<<8)
+        return reply("OK")
+    case 'm':
+        addr, length, err := parseAddrLength(args)
+        if err != nil || length > maxTransfer {
+            return reply("E01")
+        }
+        data := make([]byte, length)
+        for i := range data {
+            data[i] = stub.gameBoy.Ram.Read(uint16(addr + i))
+        }
+        return reply(hex.EncodeToString(data))
+    case 'M':
+        parts := strings.SplitN(args, ":", 2)
+        addr, length, err := parseAddrLength(parts[0])
+        if err != nil || len(parts) < 2 || length > maxTransfer {
+            return reply("E01")
+        }
+        data, err := hex.DecodeString(parts[1])
+        if err != nil || len(data) != length {
+            return reply("E01")
+        }
+        for i, val := range data {
+            stub.gameBoy.Ram.Write(uint16(addr+i), val)
+        }
+        return reply("OK")
+    case 'Z', 'z':
+        // Software and hardware breakpoints are the same thing here
+        if len(args) < 1 || (args[0] != '0' && args[0] != '1') {
+            return reply("")
+        }
+        fields := strings.Split(args, ",")
+        if len(fields) < 2 {
+            return reply("E01")
+        }
+        addr, err := strconv.ParseUint(fields[1], 16, 16)
+        if err != nil {
+            return reply("E01")
+        }
+        if packet[0] == 'Z' {
+            stub.breakpoints[uint16(addr)] = true
+        } else {
+            delete(stub.breakpoints, uint16(addr))
+        }
+        return reply("OK")
+    case 's':
+        if err := stub.resumeAt(args); err != nil {
+            return reply("E01")
+        }
+        return reply(stub.step())
+    case 'c':
+        if err := stub.resumeAt(args); err != nil {
+            return reply("E01")
+        }
+        return reply(stub.cont())
+    case 'H':
+        return reply("OK")
+    case 'k':
+        return nil, true
+    case 'D':
+        data := "OK"
+        return &data, true
+    case 'q':
+        return reply(stub.query(args))
+    case 'Q':
+        if args == "StartNoAckMode" {
+            stub.noAck = true
+            return reply("OK")
+        }
+    }
+
+    return reply("")
+}
+
+func (stub *Stub) query(args string) string {
+    switch {
+    case strings.HasPrefix(args, "Supported"):
+        return "PacketSize=4000;qXfer:features:read+;QStartNoAckMode+"
+    case args == "Attached":
+        return "1"
+    case args == "C":
+        return "QC1"
+    case args == "fThreadInfo":
+        return "m1"
+    case args == "sThreadInfo":
+        return "l"
+    case strings.HasPrefix(args, "Xfer:features:read:target.xml:"):
+        offset, length, err := parseAddrLength(strings.TrimPrefix(args, "Xfer:features:read:target.xml:"))
+        if err != nil {
+            return "E01"
+        }
+        if offset >= len(targetXml) {
+            return "l"
+        }
+        if offset+length >= len(targetXml) {
+            return "l" + targetXml[offset:]
+        }
+        return "m" + targetXml[offset:offset+length]
+    }
+
+    return ""
+}
+
+// s and c can carry an address to resume from
+func (stub *Stub) resumeAt(args string) error {
+    if args == "" {
+        return nil
+    }
+
+    addr, err := strconv.ParseUint(args, 16, 16)
+    if err != nil {
+        return err
+    }
+
+    stub.gameBoy.Cpu.SetRegister("pc", uint16(addr))
+    return nil
+}
+
+func (stub *Stub) step() string {
+    if stub.gameBoy.Step() < 0 {
+        return fmt.Sprintf("S%.2x", sigIll)
+    }
+
+    return fmt.Sprintf("S%.2x", sigTrap)
+}
+
+// Runs until a breakpoint, an unknown opcode or an interrupt from the
+// debugger
+func (stub *Stub) cont() string {
+    for i := 1; ; i++ {
+        if stub.gameBoy.Step() < 0 {
+            return fmt.Sprintf("S%.2x", sigIll)
+        }
+
+        if stub.breakpoints[stub.gameBoy.Cpu.PC()] {
+            return fmt.Sprintf("S%.2x", sigTrap)
+        }
+
+        // GDB normally only sends Ctrl-C while the target is running, but
+        // anything else is kept to answer after the stop reply
+        if i%interruptCheck == 0 {
+            select {
+            case event, ok := <-stub.events:
+                if !ok || event.interrupt {
+                    return fmt.Sprintf("S%.2x", sigInt)
+                }
+                stub.pending = append(stub.pending, event)
+            default:
+            }
+        }
+    }
+}
+
+func parseAddrLength(args string) (addr int, length int, err error) {
+    parts := strings.SplitN(args, ",", 2)
+    if len(parts) < 2 {
+        err = errors.New("expected addr,length")
+        return
+    }
+
+    a, err := strconv.ParseUint(parts[0], 16, 32)
+    if err != nil {
+        return
+    }
+
+    l, err := strconv.ParseUint(parts[1], 16, 32)
+    if err != nil {
+        return
+    }
+
+    return int(a), int(l), nil
+}
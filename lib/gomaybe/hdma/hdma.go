@@ -0,0 +1,147 @@
+package hdma
+
+import (
+    . "../ram"
+    "io"
+)
+
+const (
+    hdma1Loc = 0xFF51
+    hdma2Loc = 0xFF52
+    hdma3Loc = 0xFF53
+    hdma4Loc = 0xFF54
+    hdma5Loc = 0xFF55
+
+    lcdcLoc = 0xFF40
+
+    blockSize = 0x10
+    // Each block holds the CPU up for 8 microseconds, whatever its speed
+    blockCycles = 32
+)
+
+// The CGB's VRAM DMA. A general purpose transfer copies everything at once
+// while the CPU waits. An HBlank transfer copies one 16 byte block at the
+// start of every HBlank until it's done or cancelled.
+type Hdma struct {
+    ram    *Ram
+    src    uint16
+    dst    uint16
+    // Blocks left, less one, as HDMA5 reads it back
+    length byte
+    active bool
+    // Cycles the CPU has to wait for transfers it hasn't paid for yet
+    stall  int
+}
+
+func (hdma *Hdma) Init(ram *Ram) {
+    hdma.ram = ram
+    hdma.src = 0
+    hdma.dst = 0
+    hdma.length = 0x7F
+    hdma.active = false
+    hdma.stall = 0
+
+    // The address registers can't be read back
+    for _, loc := range []uint16{hdma1Loc, hdma2Loc, hdma3Loc, hdma4Loc} {
+        ram.HookRead(loc, func() byte { return 0xFF })
+    }
+
+    ram.HookWrite(hdma1Loc, func(val byte) { hdma.src = uint16(val)<<8 | hdma.src&0x00FF })
+    ram.HookWrite(hdma2Loc, func(val byte) { hdma.src = hdma.src&0xFF00 | uint16(val&0xF0) })
+    ram.HookWrite(hdma3Loc, func(val byte) { hdma.dst = uint16(val&0x1F)<<8 | hdma.dst&0x00FF })
+    ram.HookWrite(hdma4Loc, func(val byte) { hdma.dst = hdma.dst&0xFF00 | uint16(val&0xF0) })
+    ram.HookRead(hdma5Loc, hdma.read)
+    ram.HookWrite(hdma5Loc, hdma.write)
+}
+
+// Bit 7 is clear while an HBlank transfer is running and the rest is the
+// number of blocks left, less one. Once everything is copied it reads $FF.
+func (hdma *Hdma) read() byte {
+    if hdma.active {
+        return hdma.length
+    }
+
+    return 0x80 | hdma.length
+}
+
+func (hdma *Hdma) write(val byte) {
+    // Clearing bit 7 during an HBlank transfer stops it where it is.
+    // Setting it starts the transfer again with the new length, from
+    // wherever the address registers now point.
+    if hdma.active && val&0x80 == 0 {
+        hdma.active = false
+        return
+    }
+
+    hdma.length = val & 0x7F
+
+    if val&0x80 == 0 {
+        for hdma.copyBlock() {
+        }
+        return
+    }
+
+    hdma.active = true
+
+    // With the LCD off there are no HBlanks, so the first block goes
+    // straight away
+    if hdma.ram.Peek(lcdcLoc)&0x80 == 0 {
+        hdma.HBlank()
+    }
+}
+
+// Called by the PPU at the start of every HBlank on a visible line
+func (hdma *Hdma) HBlank() {
+    if hdma.active && !hdma.copyBlock() {
+        hdma.active = false
+    }
+}
+
+// Copies the next block into whichever VRAM bank is mapped in. Reports
+// whether there are more to go.
+func (hdma *Hdma) copyBlock() bool {
+    vram := hdma.ram.Vram(hdma.ram.VramBank())
+
+    for i := uint16(0); i < blockSize; i++ {
+        vram[(hdma.dst+i)&0x1FFF] = hdma.ram.Peek(hdma.src + i)
+    }
+
+    hdma.src += blockSize
+    // The destination wraps around within VRAM
+    hdma.dst = (hdma.dst + blockSize) & 0x1FF0
+    hdma.stall += blockCycles
+
+    hdma.length--
+    return hdma.length != 0xFF
+}
+
+// Hands over the cycles the CPU owes for transfers since the last call, in
+// cycles of the normal speed clock
+func (hdma *Hdma) Stall() (cycles int) {
+    cycles, hdma.stall = hdma.stall, 0
+    return
+}
+
+func (hdma *Hdma) SaveState(w io.Writer) error {
+    state := []byte{byte(hdma.src >> 8), byte(hdma.src), byte(hdma.dst >> 8), byte(hdma.dst), hdma.length, 0}
+    if hdma.active {
+        state[5] = 1
+    }
+
+    _, err := w.Write(state)
+    return err
+}
+
+func (hdma *Hdma) LoadState(r io.Reader) error {
+    state := make([]byte, 6)
+    if _, err := io.ReadFull(r, state); err != nil {
+        return err
+    }
+
+    hdma.src = uint16(state[0])<<8 | uint16(state[1])
+    hdma.dst = uint16(state[2])<<8 | uint16(state[3])
+    hdma.length = state[4]
+    hdma.active = state[5] != 0
+    hdma.stall = 0
+    return nil
+}
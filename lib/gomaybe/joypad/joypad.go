@@ -0,0 +1,154 @@
+package joypad
+
+import (
+    . "../ram"
+    "io"
+    "strings"
+)
+
+const joypLoc = 0xFF00
+
+// Button bits as used by Buttons and SetButtons. The low nibble lines up
+// with the direction keys in JOYP and the high nibble with the action keys.
+const (
+    Right = byte(1 << iota)
+    Left
+    Up
+    Down
+    A
+    B
+    Select
+    Start
+)
+
+var buttonNames = []string{"right", "left", "up", "down", "a", "b", "select", "start"}
+
+type Joypad struct {
+    buttons    byte
+    selectBits byte
+    // The SGB can poll up to four joypads, moving on to the next one each
+    // time P15 goes high. Only the first has anything plugged into it.
+    players    byte
+    player     byte
+    writer     func(val byte)
+}
+
+func (joypad *Joypad) Init(ram *Ram) {
+    joypad.buttons = 0
+    joypad.selectBits = 0x30
+    joypad.players = 1
+    joypad.player = 0
+    joypad.writer = nil
+
+    ram.HookRead(joypLoc, joypad.read)
+    ram.HookWrite(joypLoc, joypad.write)
+}
+
+// The buttons currently held down
+func (joypad *Joypad) Buttons() byte {
+    return joypad.buttons
+}
+
+func (joypad *Joypad) SetButtons(buttons byte) {
+    joypad.buttons = buttons
+}
+
+// Sets how many joypads the SGB polls, 1, 2 or 4, starting again from the
+// first
+func (joypad *Joypad) SetPlayers(players int) {
+    joypad.players = byte(players)
+    joypad.player = 0
+}
+
+// Sets a function to see every value written to JOYP, which is how the SGB
+// receives its packets
+func (joypad *Joypad) SetWriteHandler(handler func(val byte)) {
+    joypad.writer = handler
+}
+
+func (joypad *Joypad) read() byte {
+    // Pressed buttons read as 0 on the lines of whichever groups are selected
+    val := 0xC0 | joypad.selectBits | 0x0F
+
+    // With neither group selected the SGB answers with which joypad is
+    // being polled, $F for the first down to $C for the fourth
+    if joypad.selectBits == 0x30 {
+        return val - joypad.player
+    }
+
+    buttons := joypad.buttons
+    if joypad.player != 0 {
+        buttons = 0
+    }
+
+    if joypad.selectBits&0x10 == 0 {
+        val &^= buttons & 0x0F
+    }
+
+    if joypad.selectBits&0x20 == 0 {
+        val &^= buttons >> 4
+    }
+
+    return val
+}
+
+func (joypad *Joypad) write(val byte) {
+    if joypad.players > 1 && joypad.selectBits&0x20 == 0 && val&0x20 != 0 {
+        joypad.player = (joypad.player + 1) % joypad.players
+    }
+
+    joypad.selectBits = val & 0x30
+
+    if joypad.writer != nil {
+        joypad.writer(val)
+    }
+}
+
+func (joypad *Joypad) SaveState(w io.Writer) error {
+    _, err := w.Write([]byte{joypad.buttons, joypad.selectBits, joypad.players, joypad.player})
+    return err
+}
+
+func (joypad *Joypad) LoadState(r io.Reader) error {
+    state := make([]byte, 4)
+    if _, err := io.ReadFull(r, state); err != nil {
+        return err
+    }
+
+    joypad.buttons, joypad.selectBits = state[0], state[1]&0x30
+    joypad.players, joypad.player = state[2], state[3]
+
+    if joypad.players == 0 {
+        joypad.players = 1
+    }
+    joypad.player %= joypad.players
+    return nil
+}
+
+// Parses button names such as "a" or "start", returning false for unknown
+// names
+func ParseButton(name string) (byte, bool) {
+    for i, buttonName := range buttonNames {
+        if strings.EqualFold(name, buttonName) {
+            return 1 << uint(i), true
+        }
+    }
+
+    return 0, false
+}
+
+func ButtonsString(buttons byte) string {
+    var names []string
+
+    for i, buttonName := range buttonNames {
+        if buttons&(1<<uint(i)) != 0 {
+            names = append(names, buttonName)
+        }
+    }
+
+    if names == nil {
+        return "none"
+    }
+
+    return strings.Join(names, " ")
+}
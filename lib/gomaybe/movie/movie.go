@@ -0,0 +1,236 @@
+package movie
+
+import (
+    . "../gameboy"
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+)
+
+// Movie files hold:
+//
+//     magic     "GMBM"
+//     version   uint16
+//     checksum  uint32, CRC-32 of the ROM the movie was recorded on
+//     start     uint32 length of the starting save state, 0 for power on
+//               followed by the state itself
+//     frames    uint32 count followed by one joypad byte per frame
+//     hash      uint8 length of the machine state hash at the end, 0 if
+//               there is none, followed by the hash
+//
+// All integers are little endian.
+const (
+    movieMagic   = "GMBM"
+    movieVersion = 1
+    // A day of frames, far longer than anything gets recorded, to catch
+    // nonsense lengths before allocating them
+    maxFrames = 60 * 60 * 60 * 24
+)
+
+type Movie struct {
+    Checksum uint32
+    State    []byte
+    Inputs   []byte
+    Hash     []byte
+}
+
+func (movie *Movie) Save(w io.Writer) error {
+    var buf bytes.Buffer
+
+    buf.WriteString(movieMagic)
+    binary.Write(&buf, binary.LittleEndian, uint16(movieVersion))
+    binary.Write(&buf, binary.LittleEndian, movie.Checksum)
+    binary.Write(&buf, binary.LittleEndian, uint32(len(movie.State)))
+    buf.Write(movie.State)
+    binary.Write(&buf, binary.LittleEndian, uint32(len(movie.Inputs)))
+    buf.Write(movie.Inputs)
+    buf.WriteByte(byte(len(movie.Hash)))
+    buf.Write(movie.Hash)
+
+    _, err := w.Write(buf.Bytes())
+    return err
+}
+
+func (movie *Movie) Load(r io.Reader) error {
+    var (
+        magic    [4]byte
+        version  uint16
+        length   uint32
+        hashSize uint8
+    )
+
+    if _, err := io.ReadFull(r, magic[:]); err != nil {
+        return err
+    }
+
+    if string(magic[:]) != movieMagic {
+        return errors.New("not a movie file")
+    }
+
+    if err := binary.Read(r, binary.LittleEndian, &version); err != nil {
+        return err
+    }
+
+    if version > movieVersion {
+        return fmt.Errorf("movie version %d is newer than supported version %d", version, movieVersion)
+    }
+
+    if err := binary.Read(r, binary.LittleEndian, &movie.Checksum); err != nil {
+        return err
+    }
+
+    if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+        return err
+    }
+
+    if length > MaxStateSize {
+        return fmt.Errorf("movie's starting state is too big (%d bytes)", length)
+    }
+
+    movie.State = nil
+    if length > 0 {
+        movie.State = make([]byte, length)
+        if _, err := io.ReadFull(r, movie.State); err != nil {
+            return err
+        }
+    }
+
+    if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+        return err
+    }
+
+    if length > maxFrames {
+        return fmt.Errorf("movie has too many frames (%d)", length)
+    }
+
+    movie.Inputs = make([]byte, length)
+    if _, err := io.ReadFull(r, movie.Inputs); err != nil {
+        return err
+    }
+
+    if err := binary.Read(r, binary.LittleEndian, &hashSize); err != nil {
+        return err
+    }
+
+    movie.Hash = nil
+    if hashSize > 0 {
+        movie.Hash = make([]byte, hashSize)
+        if _, err := io.ReadFull(r, movie.Hash); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// Captures the joypad at the start of every frame. The hash of the machine
+// is taken at each frame boundary too, so that when recording stops partway
+// through a frame the movie can end on the last whole one.
+//
+// Input only changes at frame boundaries so that playback sees exactly what
+// the recording did.
+type Recorder struct {
+    movie    Movie
+    gameBoy  *GameBoy
+    lastHash []byte
+}
+
+// Starts recording from the machine's current state, or from power on if
+// fromPowerOn is set
+func (recorder *Recorder) Init(gameBoy *GameBoy, fromPowerOn bool) error {
+    recorder.gameBoy = gameBoy
+    recorder.movie = Movie{Checksum: gameBoy.Checksum()}
+    recorder.lastHash = nil
+
+    if fromPowerOn {
+        gameBoy.Reset()
+        return nil
+    }
+
+    var state bytes.Buffer
+    if err := gameBoy.SaveState(&state); err != nil {
+        return err
+    }
+
+    recorder.movie.State = state.Bytes()
+    return nil
+}
+
+// Called at the start of each frame with the buttons to hold during it
+func (recorder *Recorder) Frame(buttons byte) {
+    recorder.lastHash = recorder.gameBoy.Hash()
+    recorder.gameBoy.Joypad.SetButtons(buttons)
+    recorder.movie.Inputs = append(recorder.movie.Inputs, buttons)
+}
+
+func (recorder *Recorder) Frames() int {
+    return len(recorder.movie.Inputs)
+}
+
+// Ends the recording and writes out the movie
+func (recorder *Recorder) Finish(w io.Writer) error {
+    movie := recorder.movie
+
+    if n := len(movie.Inputs); n > 0 {
+        movie.Inputs = movie.Inputs[:n-1]
+        movie.Hash = recorder.lastHash
+    }
+
+    return movie.Save(w)
+}
+
+// Feeds a movie's inputs to the joypad frame by frame
+type Player struct {
+    movie   *Movie
+    gameBoy *GameBoy
+    frame   int
+}
+
+func (player *Player) Init(gameBoy *GameBoy, movie *Movie) error {
+    if movie.Checksum != gameBoy.Checksum() {
+        return fmt.Errorf("movie is for a different ROM (checksum %.8X, loaded %.8X)", movie.Checksum, gameBoy.Checksum())
+    }
+
+    player.gameBoy = gameBoy
+    player.movie = movie
+    player.frame = 0
+
+    if movie.State == nil {
+        gameBoy.Reset()
+        return nil
+    }
+
+    return gameBoy.LoadState(bytes.NewReader(movie.State))
+}
+
+// Called at the start of each frame. Returns false once the movie has run
+// out of input.
+func (player *Player) Frame() bool {
+    if player.Done() {
+        return false
+    }
+
+    player.gameBoy.Joypad.SetButtons(player.movie.Inputs[player.frame])
+    player.frame++
+    return true
+}
+
+func (player *Player) Done() bool {
+    return player.frame >= len(player.movie.Inputs)
+}
+
+// Compares the machine against the hash recorded at the end of the movie.
+// This should be called once the player is done.
+func (player *Player) Verify() error {
+    if player.movie.Hash == nil {
+        return errors.New("movie has no end state hash to verify against")
+    }
+
+    if hash := player.gameBoy.Hash(); !bytes.Equal(hash, player.movie.Hash) {
+        return fmt.Errorf("end state hash %x does not match recorded %x, emulation is nondeterministic", hash, player.movie.Hash)
+    }
+
+    return nil
+}
@@ -0,0 +1,42 @@
+package movie
+
+import (
+    "bytes"
+    "encoding/binary"
+    "strings"
+    "testing"
+)
+
+func TestLoadHugeLengths(t *testing.T) {
+    var movie Movie
+    movie.Inputs = []byte{1, 2, 3}
+
+    var saved bytes.Buffer
+    if err := movie.Save(&saved); err != nil {
+        t.Fatal(err)
+    }
+
+    // The state length comes after the magic, version and checksum, and the
+    // frame count right after it when there's no state
+    const stateLength = 10
+
+    hugeState := append([]byte(nil), saved.Bytes()...)
+    binary.LittleEndian.PutUint32(hugeState[stateLength:], 0xFFFFFFFF)
+
+    hugeInputs := append([]byte(nil), saved.Bytes()...)
+    binary.LittleEndian.PutUint32(hugeInputs[stateLength+4:], 0xFFFFFFFF)
+
+    for _, data := range [][]byte{hugeState, hugeInputs} {
+        if err := movie.Load(bytes.NewReader(data)); err == nil || !strings.Contains(err.Error(), "too") {
+            t.Errorf("huge length gave %v", err)
+        }
+    }
+
+    if err := movie.Load(bytes.NewReader(saved.Bytes())); err != nil {
+        t.Fatal(err)
+    }
+
+    if !bytes.Equal(movie.Inputs, []byte{1, 2, 3}) {
+        t.Errorf("inputs came back as %v", movie.Inputs)
+    }
+}
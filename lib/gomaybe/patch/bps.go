@@ -0,0 +1,108 @@
+package patch
+
+import (
+    . "../rom"
+    "errors"
+)
+
+const bpsMagic = "BPS1"
+
+// BPS actions, from the bottom two bits of each one's number
+const (
+    bpsSourceRead = iota
+    bpsTargetRead
+    bpsSourceCopy
+    bpsTargetCopy
+)
+
+var errBpsRange = errors.New("patch reaches outside the ROM")
+
+// BPS builds the target from the start with actions that copy from the
+// same place in the source, from the patch itself, or from anywhere in the
+// source or what's been written of the target so far. It's checksummed the
+// same way as UPS.
+func applyBps(source []byte, patch []byte) ([]byte, error) {
+    footer, err := readFooter(patch)
+    if err != nil {
+        return nil, err
+    }
+
+    if err := footer.checkSource(source); err != nil {
+        return nil, err
+    }
+
+    reader := reader{data: patch[:len(patch)-footerSize], pos: len(bpsMagic)}
+    reader.varint()
+    targetSize := reader.varint()
+    reader.bytes(reader.varint())
+    if reader.err != nil {
+        return nil, reader.err
+    }
+
+    if targetSize > MaxSize {
+        return nil, errTooBig
+    }
+
+    target := make([]byte, 0, targetSize)
+    sourceOffset, targetOffset := 0, 0
+
+    // Copy offsets are stored as a magnitude with the sign in the bottom bit
+    relative := func() int {
+        val := reader.varint()
+        if val&1 != 0 {
+            return -(val >> 1)
+        }
+        return val >> 1
+    }
+
+    for reader.pos < len(reader.data) {
+        action := reader.varint()
+        length := action>>2 + 1
+
+        if len(target)+length > targetSize {
+            return nil, errBpsRange
+        }
+
+        switch action & 3 {
+        case bpsSourceRead:
+            if len(target)+length > len(source) {
+                return nil, errBpsRange
+            }
+            target = append(target, source[len(target):len(target)+length]...)
+        case bpsTargetRead:
+            target = append(target, reader.bytes(length)...)
+        case bpsSourceCopy:
+            sourceOffset += relative()
+            if sourceOffset < 0 || sourceOffset+length > len(source) {
+                return nil, errBpsRange
+            }
+            target = append(target, source[sourceOffset:sourceOffset+length]...)
+            sourceOffset += length
+        case bpsTargetCopy:
+            targetOffset += relative()
+            if targetOffset < 0 || targetOffset >= len(target) {
+                return nil, errBpsRange
+            }
+            // The copy can overlap what it's writing, so it goes a byte at a
+            // time
+            for i := 0; i < length; i++ {
+                target = append(target, target[targetOffset])
+                targetOffset++
+            }
+        }
+
+        if reader.err != nil {
+            return nil, reader.err
+        }
+    }
+
+    if len(target) != targetSize {
+        return nil, errors.New("patch doesn't fill the whole ROM")
+    }
+
+    if err := footer.checkTarget(target); err != nil {
+        return nil, err
+    }
+
+    return target, nil
+}
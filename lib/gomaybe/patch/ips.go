@@ -0,0 +1,69 @@
+package patch
+
+import (
+    . "../rom"
+)
+
+const (
+    ipsMagic = "PATCH"
+    // The offset of the last record spells out EOF
+    ipsEof = 0x454F46
+)
+
+// IPS is a list of records, each a three byte offset and a two byte length
+// followed by that many bytes. A zero length means a run of one byte
+// instead, with a two byte count and the byte. Everything is big endian. An
+// optional three byte length after the end truncates the ROM.
+func applyIps(source []byte, patch []byte) ([]byte, error) {
+    target := append([]byte(nil), source...)
+    reader := reader{data: patch, pos: len(ipsMagic)}
+
+    for {
+        offset := int(reader.byte())<<16 | int(reader.byte())<<8 | int(reader.byte())
+        if reader.err != nil {
+            return nil, reader.err
+        }
+
+        if offset == ipsEof {
+            break
+        }
+
+        size := int(reader.byte())<<8 | int(reader.byte())
+        var data []byte
+
+        if size == 0 {
+            count := int(reader.byte())<<8 | int(reader.byte())
+            val := reader.byte()
+
+            data = make([]byte, count)
+            for i := range data {
+                data[i] = val
+            }
+        } else {
+            data = reader.bytes(size)
+        }
+
+        if reader.err != nil {
+            return nil, reader.err
+        }
+
+        end := offset + len(data)
+        if end > MaxSize {
+            return nil, errTooBig
+        }
+
+        if end > len(target) {
+            target = append(target, make([]byte, end-len(target))...)
+        }
+        copy(target[offset:], data)
+    }
+
+    if len(patch)-reader.pos >= 3 {
+        size := int(reader.byte())<<16 | int(reader.byte())<<8 | int(reader.byte())
+        if size < len(target) {
+            target = target[:size]
+        }
+    }
+
+    return target, nil
+}
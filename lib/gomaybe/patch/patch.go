@@ -0,0 +1,130 @@
+package patch
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "hash/crc32"
+)
+
+// File extensions of the formats Apply understands, in the order they're
+// looked for next to a ROM
+var Extensions = []string{".ips", ".ups", ".bps"}
+
+var (
+    errTooBig    = errors.New("patched ROM would be too big")
+    errTruncated = errors.New("patch is truncated")
+    errWrongRom  = errors.New("patch is for a different ROM")
+)
+
+// Returns a patched copy of source, working out the format from the patch's
+// magic number. source itself isn't changed.
+func Apply(source []byte, patch []byte) ([]byte, error) {
+    switch {
+    case bytes.HasPrefix(patch, []byte(ipsMagic)):
+        return applyIps(source, patch)
+    case bytes.HasPrefix(patch, []byte(upsMagic)):
+        return applyUps(source, patch)
+    case bytes.HasPrefix(patch, []byte(bpsMagic)):
+        return applyBps(source, patch)
+    }
+
+    return nil, errors.New("not an IPS, UPS or BPS patch")
+}
+
+// Reads through a patch, remembering the first thing that went wrong so
+// callers can check once at the end
+type reader struct {
+    data []byte
+    pos  int
+    err  error
+}
+
+func (reader *reader) byte() byte {
+    if reader.pos >= len(reader.data) {
+        reader.err = errTruncated
+        return 0
+    }
+
+    reader.pos++
+    return reader.data[reader.pos-1]
+}
+
+func (reader *reader) bytes(n int) []byte {
+    if n < 0 || reader.pos+n > len(reader.data) {
+        reader.err = errTruncated
+        reader.pos = len(reader.data)
+        return nil
+    }
+
+    reader.pos += n
+    return reader.data[reader.pos-n : reader.pos]
+}
+
+// UPS and BPS numbers are seven bits at a time, least significant first,
+// with the top bit set on the last byte. Each continuation also adds one so
+// every number has exactly one encoding.
+func (reader *reader) varint() int {
+    val, shift := 0, 1
+
+    for reader.err == nil {
+        x := reader.byte()
+        val += int(x&0x7F) * shift
+        if x&0x80 != 0 {
+            break
+        }
+
+        // Nothing in a ROM patch gets anywhere near this big
+        if shift > 1<<28 {
+            reader.err = errors.New("patch has an invalid number")
+            break
+        }
+
+        shift <<= 7
+        val += shift
+    }
+
+    return val
+}
+
+// UPS and BPS end with the CRC-32s of the source, the target and the patch
+// up to that last checksum
+type footer struct {
+    source, target, patch uint32
+}
+
+const footerSize = 12
+
+func readFooter(patch []byte) (footer footer, err error) {
+    if len(patch) < footerSize {
+        return footer, errTruncated
+    }
+
+    end := patch[len(patch)-footerSize:]
+    footer.source = binary.LittleEndian.Uint32(end)
+    footer.target = binary.LittleEndian.Uint32(end[4:])
+    footer.patch = binary.LittleEndian.Uint32(end[8:])
+
+    if crc32.ChecksumIEEE(patch[:len(patch)-4]) != footer.patch {
+        return footer, errors.New("patch is corrupt, its checksum doesn't match")
+    }
+
+    return footer, nil
+}
+
+func (footer footer) checkSource(source []byte) error {
+    if crc32.ChecksumIEEE(source) != footer.source {
+        return errWrongRom
+    }
+
+    return nil
+}
+
+func (footer footer) checkTarget(target []byte) error {
+    if sum := crc32.ChecksumIEEE(target); sum != footer.target {
+        return fmt.Errorf("patched ROM has checksum %.8X, expected %.8X", sum, footer.target)
+    }
+
+    return nil
+}
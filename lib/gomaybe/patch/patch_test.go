@@ -0,0 +1,144 @@
+package patch
+
+import (
+    "bytes"
+    "encoding/binary"
+    "hash/crc32"
+    "testing"
+)
+
+func varint(val int) (encoded []byte) {
+    for {
+        x := byte(val & 0x7F)
+        val >>= 7
+        if val == 0 {
+            return append(encoded, x|0x80)
+        }
+
+        encoded = append(encoded, x)
+        val--
+    }
+}
+
+// Adds the source, target and patch checksums that end UPS and BPS patches
+func withFooter(patch []byte, source []byte, target []byte) []byte {
+    sums := make([]byte, 8)
+    binary.LittleEndian.PutUint32(sums, crc32.ChecksumIEEE(source))
+    binary.LittleEndian.PutUint32(sums[4:], crc32.ChecksumIEEE(target))
+    patch = append(patch, sums...)
+
+    sum := make([]byte, 4)
+    binary.LittleEndian.PutUint32(sum, crc32.ChecksumIEEE(patch))
+    return append(patch, sum...)
+}
+
+func checkApply(t *testing.T, source []byte, patch []byte, want []byte) {
+    got, err := Apply(source, patch)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if !bytes.Equal(got, want) {
+        t.Errorf("got % x, want % x", got, want)
+    }
+}
+
+func TestIps(t *testing.T) {
+    source := []byte{0, 1, 2, 3, 4, 5, 6, 7}
+
+    patch := []byte(ipsMagic)
+    // Two bytes at 2
+    patch = append(patch, 0x00, 0x00, 0x02, 0x00, 0x02, 0xAA, 0xBB)
+    // A run of three past the end
+    patch = append(patch, 0x00, 0x00, 0x07, 0x00, 0x00, 0x00, 0x03, 0xCC)
+    patch = append(patch, "EOF"...)
+
+    checkApply(t, source, patch, []byte{0, 1, 0xAA, 0xBB, 4, 5, 6, 0xCC, 0xCC, 0xCC})
+
+    // Truncated to 4 bytes
+    checkApply(t, source, append(patch, 0x00, 0x00, 0x04), []byte{0, 1, 0xAA, 0xBB})
+
+    if source[2] != 2 {
+        t.Error("source was changed")
+    }
+
+    // A run of $FFFF at $FFFFFF
+    huge := []byte(ipsMagic)
+    huge = append(huge, 0xFF, 0xFF, 0xFF, 0x00, 0x00, 0xFF, 0xFF, 0x00)
+    huge = append(huge, "EOF"...)
+
+    if _, err := Apply(source, huge); err != errTooBig {
+        t.Errorf("patching past the size limit gave %v", err)
+    }
+}
+
+func TestUps(t *testing.T) {
+    source := []byte{0, 1, 2, 3, 4, 5, 6, 7}
+    target := []byte{0, 1, 9, 3, 4, 5, 6, 0, 8}
+
+    patch := []byte(upsMagic)
+    patch = append(patch, varint(len(source))...)
+    patch = append(patch, varint(len(target))...)
+    // Skip 2, XOR in 2^9, then skip 3 more and XOR in 7^0 and 0^8 past the
+    // end of the source
+    patch = append(patch, varint(2)...)
+    patch = append(patch, 2^9, 0)
+    patch = append(patch, varint(3)...)
+    patch = append(patch, 7, 8, 0)
+    patch = withFooter(patch, source, target)
+
+    checkApply(t, source, patch, target)
+
+    if _, err := Apply([]byte{1, 2, 3}, patch); err != errWrongRom {
+        t.Errorf("patching the wrong ROM gave %v", err)
+    }
+}
+
+func TestBps(t *testing.T) {
+    source := []byte("abcdefgh")
+    target := []byte("abcXYefxyxyxy")
+
+    action := func(kind int, length int) []byte {
+        return varint((length-1)<<2 | kind)
+    }
+
+    patch := []byte(bpsMagic)
+    patch = append(patch, varint(len(source))...)
+    patch = append(patch, varint(len(target))...)
+    patch = append(patch, varint(0)...)
+    // abc from the same place
+    patch = append(patch, action(bpsSourceRead, 3)...)
+    // XY from the patch
+    patch = append(patch, action(bpsTargetRead, 2)...)
+    patch = append(patch, "XY"...)
+    // ef from offset 4 of the source
+    patch = append(patch, action(bpsSourceCopy, 2)...)
+    patch = append(patch, varint(4<<1)...)
+    // xy from the patch, then repeated from 2 back in the target
+    patch = append(patch, action(bpsTargetRead, 2)...)
+    patch = append(patch, "xy"...)
+    patch = append(patch, action(bpsTargetCopy, 4)...)
+    patch = append(patch, varint(7<<1)...)
+    patch = withFooter(patch, source, target)
+
+    checkApply(t, source, patch, target)
+
+    corrupt := append([]byte(nil), patch...)
+    corrupt[len(bpsMagic)+4] ^= 1
+    if _, err := Apply(source, corrupt); err == nil {
+        t.Error("corrupt patch applied")
+    }
+}
+
+func FuzzApply(f *testing.F) {
+    f.Add([]byte("PATCH\x00\x00\x01\x00\x01\xffEOF"))
+    f.Add([]byte("UPS1\x81\x81\x80\x01\x00"))
+    f.Add([]byte("BPS1\x81\x81\x80\x80"))
+
+    source := []byte{0x00, 0x01}
+
+    f.Fuzz(func(t *testing.T, patch []byte) {
+        Apply(source, patch)
+        Apply(source, withFooter(patch, source, source))
+    })
+}
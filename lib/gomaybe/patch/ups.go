@@ -0,0 +1,60 @@
+package patch
+
+import (
+    . "../rom"
+)
+
+const upsMagic = "UPS1"
+
+// UPS gives the source and target sizes and then a series of hunks, each a
+// number of bytes to skip followed by bytes to XOR in, ending with a zero.
+// Checksums at the end make sure the right ROM is being patched.
+func applyUps(source []byte, patch []byte) ([]byte, error) {
+    footer, err := readFooter(patch)
+    if err != nil {
+        return nil, err
+    }
+
+    if err := footer.checkSource(source); err != nil {
+        return nil, err
+    }
+
+    reader := reader{data: patch[:len(patch)-footerSize], pos: len(upsMagic)}
+    reader.varint()
+    targetSize := reader.varint()
+    if reader.err != nil {
+        return nil, reader.err
+    }
+
+    if targetSize > MaxSize {
+        return nil, errTooBig
+    }
+
+    target := make([]byte, targetSize)
+    copy(target, source)
+
+    for pos := 0; reader.pos < len(reader.data); pos++ {
+        pos += reader.varint()
+
+        for ; ; pos++ {
+            x := reader.byte()
+            if reader.err != nil {
+                return nil, reader.err
+            }
+
+            if x == 0 {
+                break
+            }
+
+            if pos < len(target) {
+                target[pos] ^= x
+            }
+        }
+    }
+
+    if err := footer.checkTarget(target); err != nil {
+        return nil, err
+    }
+
+    return target, nil
+}
@@ -0,0 +1,270 @@
+package ppu
+
+import (
+    "errors"
+    "sort"
+    "strings"
+)
+
+const (
+    // The boot ROM sums the whole 16 bytes, including the CGB flag that a
+    // DMG game has as the last letter of its title
+    titleStart       = 0x134
+    titleEnd         = 0x144
+    newLicenseeLoc   = 0x144
+    oldLicenseeLoc   = 0x14B
+    // The old licensee byte says to look at the new licensee code instead
+    useNewLicensee   = 0x33
+    nintendoLicensee = 0x01
+)
+
+// The colours a CGB gives a DMG game's background and two sprite palettes,
+// as 15 bit CGB colours, lightest first
+type CompatibilityPalettes struct {
+    Bg, Obj0, Obj1 [4]uint16
+}
+
+// The colours in the CGB boot ROM, four to a palette
+var compatColors = []uint16{
+    0x7FFF, 0x32BF, 0x00D0, 0x0000,
+    0x639F, 0x4279, 0x15B0, 0x04CB,
+    0x7FFF, 0x6E31, 0x454A, 0x0000,
+    0x7FFF, 0x1BEF, 0x0200, 0x0000,
+    0x7FFF, 0x421F, 0x1CF2, 0x0000,
+    0x7FFF, 0x5294, 0x294A, 0x0000,
+    0x7FFF, 0x03FF, 0x012F, 0x0000,
+    0x7FFF, 0x03EF, 0x01D6, 0x0000,
+    0x7FFF, 0x42B5, 0x3DC8, 0x0000,
+    0x7E74, 0x03FF, 0x0180, 0x0000,
+    0x67FF, 0x77AC, 0x1A13, 0x2D6B,
+    0x7ED6, 0x4BFF, 0x2175, 0x0000,
+    0x53FF, 0x4A5F, 0x7E52, 0x0000,
+    0x4FFF, 0x7ED2, 0x3A4C, 0x1CE0,
+    0x03ED, 0x7FFF, 0x255F, 0x0000,
+    0x036A, 0x021F, 0x03FF, 0x7FFF,
+    0x7FFF, 0x01DF, 0x0112, 0x0000,
+    0x231F, 0x035F, 0x00F2, 0x0009,
+    0x7FFF, 0x03EA, 0x011F, 0x0000,
+    0x299F, 0x001A, 0x000C, 0x0000,
+    0x7FFF, 0x027F, 0x001F, 0x0000,
+    0x7FFF, 0x03E0, 0x0206, 0x0120,
+    0x7FFF, 0x7EEB, 0x001F, 0x7C00,
+    0x7FFF, 0x3FFF, 0x7E00, 0x001F,
+    0x7FFF, 0x03FF, 0x001F, 0x0000,
+    0x03FF, 0x001F, 0x000C, 0x0000,
+    0x7FFF, 0x033F, 0x0193, 0x0000,
+    0x0000, 0x4200, 0x037F, 0x7FFF,
+    0x7FFF, 0x7E8C, 0x7C00, 0x0000,
+    0x7FFF, 0x1BEF, 0x6180, 0x0000,
+}
+
+// Where each of a game's palettes starts in compatColors. They're normally
+// whole palettes, but the boot ROM has three combinations that start a
+// colour early and so take the last colour of the palette before.
+type compatCombination struct {
+    obj0, obj1, bg int
+}
+
+// A combination of whole palettes, by their number in compatColors
+func wholePalettes(obj0 int, obj1 int, bg int) compatCombination {
+    return compatCombination{obj0 * 4, obj1 * 4, bg * 4}
+}
+
+var compatCombinations = []compatCombination{
+    wholePalettes(4, 4, 29),
+    wholePalettes(18, 18, 18),
+    wholePalettes(20, 20, 20),
+    wholePalettes(24, 24, 24),
+    wholePalettes(9, 9, 9),
+    wholePalettes(0, 0, 0),
+    wholePalettes(27, 27, 27),
+    wholePalettes(5, 5, 5),
+    wholePalettes(12, 12, 12),
+    wholePalettes(26, 26, 26),
+    wholePalettes(16, 8, 8),
+    wholePalettes(4, 28, 28),
+    wholePalettes(4, 2, 2),
+    wholePalettes(3, 4, 4),
+    wholePalettes(4, 29, 29),
+    wholePalettes(28, 4, 28),
+    wholePalettes(2, 17, 2),
+    wholePalettes(16, 16, 8),
+    wholePalettes(4, 4, 7),
+    wholePalettes(4, 4, 18),
+    wholePalettes(4, 4, 20),
+    wholePalettes(19, 19, 9),
+    {4*4 - 1, 4*4 - 1, 11 * 4},
+    wholePalettes(17, 17, 2),
+    wholePalettes(4, 4, 2),
+    wholePalettes(4, 4, 3),
+    wholePalettes(28, 28, 0),
+    wholePalettes(3, 3, 0),
+    wholePalettes(0, 0, 1),
+    wholePalettes(18, 22, 18),
+    wholePalettes(20, 22, 20),
+    wholePalettes(24, 22, 24),
+    wholePalettes(16, 22, 8),
+    wholePalettes(17, 4, 13),
+    {28*4 - 1, 0 * 4, 14 * 4},
+    {28*4 - 1, 4 * 4, 15 * 4},
+    wholePalettes(19, 22, 9),
+    wholePalettes(16, 28, 10),
+    wholePalettes(4, 23, 28),
+    wholePalettes(17, 22, 2),
+    wholePalettes(4, 0, 2),
+    wholePalettes(4, 28, 3),
+    wholePalettes(28, 3, 0),
+    wholePalettes(3, 28, 4),
+    wholePalettes(21, 28, 4),
+    wholePalettes(3, 28, 0),
+    wholePalettes(25, 3, 28),
+    wholePalettes(0, 28, 8),
+    wholePalettes(4, 3, 28),
+    wholePalettes(28, 3, 6),
+    wholePalettes(4, 28, 29),
+}
+
+func (combination compatCombination) palettes() (palettes CompatibilityPalettes) {
+    copy(palettes.Obj0[:], compatColors[combination.obj0:])
+    copy(palettes.Obj1[:], compatColors[combination.obj1:])
+    copy(palettes.Bg[:], compatColors[combination.bg:])
+    return
+}
+
+// The combinations that can be picked by holding buttons while the CGB boot
+// logo shows, named after the buttons
+var compatPresets = map[string]int{
+    "up":      5,
+    "up+a":    43,
+    "up+b":    28,
+    "left":    48,
+    "left+a":  40,
+    "left+b":  7,
+    "down":    8,
+    "down+a":  3,
+    "down+b":  49,
+    "right":   1,
+    "right+a": 0,
+    "right+b": 6,
+}
+
+// Nintendo's own games get palettes picked by the boot ROM, found by the sum
+// of the 16 bytes of their title. Everything else gets the first combination.
+var compatChecksums = []byte{
+    0x00, 0x88, 0x16, 0x36, 0xD1, 0xDB, 0xF2, 0x3C, 0x8C, 0x92, 0x3D, 0x5C, 0x58, 0xC9, 0x3E, 0x70,
+    0x1D, 0x59, 0x69, 0x19, 0x35, 0xA8, 0x14, 0xAA, 0x75, 0x95, 0x99, 0x34, 0x6F, 0x15, 0xFF, 0x97,
+    0x4B, 0x90, 0x17, 0x10, 0x39, 0xF7, 0xF6, 0xA2, 0x49, 0x4E, 0x43, 0x68, 0xE0, 0x8B, 0xF0, 0xCE,
+    0x0C, 0x29, 0xE8, 0xB7, 0x86, 0x9A, 0x52, 0x01, 0x9D, 0x71, 0x9C, 0xBD, 0x5D, 0x6D, 0x67, 0x3F,
+    0x6B,
+    // More than one title has each of these sums
+    0xB3, 0x46, 0x28, 0xA5, 0xC6, 0xD3, 0x27, 0x61, 0x18, 0x66, 0x6A, 0xBF, 0x0D, 0xF4,
+}
+
+// Where the sums shared by more than one title start in compatChecksums
+const compatShared = 0x41
+
+// The fourth letters of the titles with shared sums, in rows of one for
+// each sum. SUPER MARIOLAND and METROID2 both add up to $46, for instance,
+// and are told apart by the E and the R.
+const compatLetters = "BEFAARBEKEK R-URAR INAILICE R"
+
+// The combination for each entry in compatChecksums, then for each letter
+// in compatLetters after the first row
+var compatTitleCombinations = []byte{
+    0, 4, 5, 35, 34, 3, 31, 15, 10, 5, 19, 36, 7, 37, 30, 44,
+    21, 32, 31, 20, 5, 33, 13, 14, 5, 29, 5, 18, 9, 3, 2, 26,
+    25, 25, 41, 42, 26, 45, 42, 45, 36, 38, 26, 42, 30, 41, 34, 34,
+    5, 42, 6, 5, 33, 25, 42, 42, 40, 2, 16, 25, 42, 42, 5, 0,
+    39,
+    36, 22, 25, 6, 32, 12, 36, 11, 39, 18, 39, 24, 31, 50,
+    17, 46, 6, 27, 0, 47, 41, 41, 0, 0, 19, 34, 23, 18,
+    29,
+}
+
+// Names of the palettes that can be picked with buttons at boot
+func CompatibilityPresets() []string {
+    names := make([]string, 0, len(compatPresets))
+    for name := range compatPresets {
+        names = append(names, name)
+    }
+
+    sort.Strings(names)
+    return names
+}
+
+// Takes the buttons that pick a palette at boot, e.g. left+b
+func ParseCompatibilityPalettes(name string) (CompatibilityPalettes, error) {
+    if combination, ok := compatPresets[strings.ToLower(name)]; ok {
+        return compatCombinations[combination].palettes(), nil
+    }
+
+    return CompatibilityPalettes{}, errors.New("unknown boot palette " + name)
+}
+
+// The same colours for the background and both sprite palettes
+func DmgCompatibilityPalettes(palette DmgPalette) (palettes CompatibilityPalettes) {
+    for i, c := range palette {
+        val := uint16(c.R>>3) | uint16(c.G>>3)<<5 | uint16(c.B>>3)<<10
+        palettes.Bg[i], palettes.Obj0[i], palettes.Obj1[i] = val, val, val
+    }
+
+    return
+}
+
+// The background colours, for showing on a DMG
+func (palettes CompatibilityPalettes) Dmg() (palette DmgPalette) {
+    for i, val := range palettes.Bg {
+        palette[i] = Rgb555(val)
+    }
+
+    return
+}
+
+// Picks palettes for a DMG game the way the CGB boot ROM does when nobody
+// holds any buttons
+func LookupCompatibilityPalettes(romData []byte) CompatibilityPalettes {
+    if len(romData) <= oldLicenseeLoc || !nintendoGame(romData) {
+        return compatCombinations[0].palettes()
+    }
+
+    title := romData[titleStart:titleEnd]
+    sum := titleChecksum(title)
+
+    for i, checksum := range compatChecksums {
+        if checksum != sum {
+            continue
+        }
+
+        if i < compatShared {
+            return compatCombinations[compatTitleCombinations[i]].palettes()
+        }
+
+        // Down the column of letters for this sum
+        rowLength := len(compatChecksums) - compatShared
+        for j := i - compatShared; j < len(compatLetters); j += rowLength {
+            if compatLetters[j] == title[3] {
+                return compatCombinations[compatTitleCombinations[compatShared+j]].palettes()
+            }
+        }
+
+        break
+    }
+
+    return compatCombinations[0].palettes()
+}
+
+func nintendoGame(romData []byte) bool {
+    if romData[oldLicenseeLoc] == useNewLicensee {
+        return string(romData[newLicenseeLoc:newLicenseeLoc+2]) == "01"
+    }
+
+    return romData[oldLicenseeLoc] == nintendoLicensee
+}
+
+func titleChecksum(title []byte) (sum byte) {
+    for _, c := range title {
+        sum += c
+    }
+
+    return
+}
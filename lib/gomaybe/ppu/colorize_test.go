@@ -0,0 +1,83 @@
+package ppu
+
+import (
+    "testing"
+)
+
+// A ROM header with just a title and a licensee
+func titleRom(title string, licensee byte) []byte {
+    romData := make([]byte, 0x150)
+    copy(romData[titleStart:], title)
+    romData[oldLicenseeLoc] = licensee
+    return romData
+}
+
+func TestCompatTables(t *testing.T) {
+    rows := len(compatChecksums) - compatShared
+    if want := compatShared + len(compatLetters); len(compatTitleCombinations) != want {
+        t.Errorf("%d title combinations, want %d", len(compatTitleCombinations), want)
+    }
+
+    if len(compatLetters)%rows != 1 {
+        t.Errorf("%d letters don't fit rows of %d", len(compatLetters), rows)
+    }
+
+    for i, combination := range compatTitleCombinations {
+        if int(combination) >= len(compatCombinations) {
+            t.Errorf("title %d has combination %d", i, combination)
+        }
+    }
+}
+
+func TestLookupCompatibilityPalettes(t *testing.T) {
+    var (
+        yellow    = [4]uint16{0x03FF, 0x001F, 0x000C, 0x0000}
+        green     = [4]uint16{0x7FFF, 0x1BEF, 0x0200, 0x0000}
+        red       = [4]uint16{0x7FFF, 0x421F, 0x1CF2, 0x0000}
+        blue      = [4]uint16{0x7FFF, 0x7E8C, 0x7C00, 0x0000}
+        darkGreen = [4]uint16{0x7FFF, 0x1BEF, 0x6180, 0x0000}
+        orange    = [4]uint16{0x7FFF, 0x03FF, 0x001F, 0x0000}
+    )
+
+    tests := []struct {
+        title    string
+        licensee byte
+        want     CompatibilityPalettes
+    }{
+        {"TETRIS", nintendoLicensee, CompatibilityPalettes{orange, orange, orange}},
+        {"POKEMON RED", nintendoLicensee, CompatibilityPalettes{red, green, red}},
+        // Told apart from other titles adding up to $61 by the E
+        {"POKEMON BLUE", nintendoLicensee, CompatibilityPalettes{blue, red, blue}},
+        {"METROID2", nintendoLicensee, CompatibilityPalettes{blue, yellow, green}},
+        // These palettes start a colour early
+        {"SUPER MARIOLAND", nintendoLicensee, CompatibilityPalettes{
+            [4]uint16{0x7ED6, 0x4BFF, 0x2175, 0x0000},
+            [4]uint16{0x0000, 0x7FFF, 0x421F, 0x1CF2},
+            [4]uint16{0x0000, 0x7FFF, 0x421F, 0x1CF2},
+        }},
+        // Adds up to $46 like SUPER MARIOLAND but doesn't have its E
+        {"ABCX(", nintendoLicensee, CompatibilityPalettes{darkGreen, red, red}},
+        {"TETRIS", 0x00, CompatibilityPalettes{darkGreen, red, red}},
+    }
+
+    for _, test := range tests {
+        if got := LookupCompatibilityPalettes(titleRom(test.title, test.licensee)); got != test.want {
+            t.Errorf("%s from licensee %.2X got %v, want %v", test.title, test.licensee, got, test.want)
+        }
+    }
+}
+
+func TestParseCompatibilityPalettes(t *testing.T) {
+    palettes, err := ParseCompatibilityPalettes("UP+A")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if palettes.Bg[1] != 0x421F || palettes.Obj0[1] != 0x1BEF || palettes.Obj1[1] != 0x7E8C {
+        t.Errorf("up+a got %v", palettes)
+    }
+
+    if _, err := ParseCompatibilityPalettes("up+start"); err == nil {
+        t.Error("up+start parsed")
+    }
+}
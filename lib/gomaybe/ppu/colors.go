@@ -0,0 +1,192 @@
+package ppu
+
+import (
+    "errors"
+    "fmt"
+    "image/color"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// Four colours for the DMG's shades, lightest first
+type DmgPalette [4]color.RGBA
+
+var dmgPresets = map[string]DmgPalette{
+    "gray":   DmgShades,
+    "green":  dmgPaletteHex(0x9BBC0F, 0x8BAC0F, 0x306230, 0x0F380F),
+    "pocket": dmgPaletteHex(0xC4CFA1, 0x8B956D, 0x4D533C, 0x1F1F1F),
+    "light":  dmgPaletteHex(0x00B581, 0x009A71, 0x00694A, 0x004F3B),
+}
+
+func dmgPaletteHex(colors ...uint32) (palette DmgPalette) {
+    for i, rgb := range colors {
+        palette[i] = color.RGBA{byte(rgb >> 16), byte(rgb >> 8), byte(rgb), 0xFF}
+    }
+
+    return
+}
+
+// Names of the built in DMG palettes
+func DmgPresets() []string {
+    names := make([]string, 0, len(dmgPresets))
+    for name := range dmgPresets {
+        names = append(names, name)
+    }
+
+    sort.Strings(names)
+    return names
+}
+
+// Takes either the name of a preset or four hex colours separated by
+// commas, lightest first, e.g. e0f8d0,88c070,346856,081820
+func ParseDmgPalette(spec string) (DmgPalette, error) {
+    if palette, ok := dmgPresets[strings.ToLower(spec)]; ok {
+        return palette, nil
+    }
+
+    fields := strings.Split(spec, ",")
+    if len(fields) != 4 {
+        return DmgPalette{}, fmt.Errorf("unknown palette %q, expected one of %s or four hex colours", spec, strings.Join(DmgPresets(), ", "))
+    }
+
+    colors := make([]uint32, 4)
+    for i, field := range fields {
+        rgb, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(field), "#"), 16, 24)
+        if err != nil {
+            return DmgPalette{}, errors.New("invalid colour " + field)
+        }
+        colors[i] = uint32(rgb)
+    }
+
+    return dmgPaletteHex(colors...), nil
+}
+
+// How 15 bit CGB colours are turned into what a modern display shows
+type Correction int
+
+const (
+    // Each channel is scaled up as it is, which looks a lot more saturated
+    // than a real CGB screen
+    CorrectionNone Correction = iota
+    // Mixes the channels and dims them the way the CGB's LCD does
+    CorrectionLcd
+)
+
+var correctionNames = []string{"none", "lcd"}
+
+func (correction Correction) String() string {
+    if correction < 0 || int(correction) >= len(correctionNames) {
+        return "unknown"
+    }
+
+    return correctionNames[correction]
+}
+
+func ParseCorrection(name string) (Correction, error) {
+    for i, known := range correctionNames {
+        if strings.ToLower(name) == known {
+            return Correction(i), nil
+        }
+    }
+
+    return CorrectionNone, errors.New("unknown colour correction " + name + ", expected one of " + strings.Join(correctionNames, ", "))
+}
+
+// The LCD bleeds some of each channel into the others and can't get as
+// bright as a modern display, so the result is mixed from all three and
+// topped out at 240
+func lcdColor(val uint16) color.RGBA {
+    r, g, b := int(val&0x1F), int(val>>5&0x1F), int(val>>10&0x1F)
+
+    mix := func(c int) uint8 {
+        if c > 960 {
+            c = 960
+        }
+        return uint8(c >> 2)
+    }
+
+    return color.RGBA{mix(r*26 + g*4 + b*2), mix(g*24 + b*8), mix(r*6 + g*4 + b*22), 0xFF}
+}
+
+// Sets the shades for DMG games on a DMG. On a CGB they get their colours
+// from its palettes instead.
+func (ppu *Ppu) SetDmgPalette(palette DmgPalette) {
+    ppu.dmgPalette = palette
+}
+
+func (ppu *Ppu) SetCorrection(correction Correction) {
+    ppu.correction = correction
+    ppu.corrected = nil
+
+    if correction == CorrectionLcd {
+        ppu.corrected = new([0x8000]color.RGBA)
+        for val := range ppu.corrected {
+            ppu.corrected[val] = lcdColor(uint16(val))
+        }
+    }
+}
+
+func (ppu *Ppu) Correction() Correction {
+    return ppu.correction
+}
+
+// Loads the palettes the CGB boot ROM picks for a DMG game into palette
+// memory and draws the game with them: BGP indexes background palette 0 and
+// OBP0 and OBP1 index sprite palettes 0 and 1
+func (ppu *Ppu) SetCompatibilityPalettes(palettes CompatibilityPalettes) {
+    ppu.compat = true
+
+    for i, val := range palettes.Bg {
+        ppu.bgPalettes.setColor(0, i, val)
+    }
+
+    for i := range palettes.Obj0 {
+        ppu.objPalettes.setColor(0, i, palettes.Obj0[i])
+        ppu.objPalettes.setColor(1, i, palettes.Obj1[i])
+    }
+}
+
+func (ppu *Ppu) cgbColor(val uint16) color.RGBA {
+    if ppu.corrected != nil {
+        return ppu.corrected[val&0x7FFF]
+    }
+
+    return Rgb555(val)
+}
+
+// The colour of a background pixel, given its BG map attributes in CGB
+// mode or BGP otherwise
+func (ppu *Ppu) bgColor(attr byte, bgp byte, index int) color.RGBA {
+    switch {
+    case ppu.cgb:
+        return ppu.cgbColor(ppu.bgPalettes.color(int(attr&attrPalette), index))
+    case ppu.compat:
+        return ppu.cgbColor(ppu.bgPalettes.color(0, dmgShade(bgp, index)))
+    }
+
+    return ppu.dmgPalette[dmgShade(bgp, index)]
+}
+
+func (ppu *Ppu) objColor(flags byte, index int) color.RGBA {
+    if ppu.cgb {
+        return ppu.cgbColor(ppu.objPalettes.color(int(flags&attrPalette), index))
+    }
+
+    obp, palette := ppu.obp(flags)
+
+    if ppu.compat {
+        return ppu.cgbColor(ppu.objPalettes.color(palette, dmgShade(obp, index)))
+    }
+
+    return ppu.dmgPalette[dmgShade(obp, index)]
+}
+
+// The DMG palette register a sprite uses and which of the two it is
+func (ppu *Ppu) obp(flags byte) (byte, int) {
+    if flags&attrDmgObp1 != 0 {
+        return ppu.ram.Peek(obp1Loc), 1
+    }
+
+    return ppu.ram.Peek(obp0Loc), 0
+}
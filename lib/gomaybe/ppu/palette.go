@@ -0,0 +1,91 @@
+package ppu
+
+import (
+    "image/color"
+)
+
+const (
+    bcpsLoc = 0xFF68
+    bcpdLoc = 0xFF69
+    ocpsLoc = 0xFF6A
+    ocpdLoc = 0xFF6B
+
+    // Eight palettes of four colours, two bytes each
+    paletteRamSize = 8 * 4 * 2
+)
+
+// The DMG's four shades, lightest first
+var DmgShades = [4]color.RGBA{
+    {0xFF, 0xFF, 0xFF, 0xFF},
+    {0xAA, 0xAA, 0xAA, 0xFF},
+    {0x55, 0x55, 0x55, 0xFF},
+    {0x00, 0x00, 0x00, 0xFF},
+}
+
+// One set of CGB palette memory, either for the background or for sprites.
+// It's only reachable a byte at a time through a pair of registers: the
+// specification register picks a byte and can make it step forward after
+// every write, and the data register reads and writes that byte.
+type paletteRam struct {
+    data [paletteRamSize]byte
+    spec byte
+}
+
+func (paletteRam *paletteRam) init() {
+    // The boot ROM leaves every colour white
+    for i := range paletteRam.data {
+        paletteRam.data[i] = 0xFF
+    }
+
+    paletteRam.spec = 0
+}
+
+func (paletteRam *paletteRam) readSpec() byte {
+    // Bit 6 isn't used and reads as 1
+    return paletteRam.spec | 0x40
+}
+
+func (paletteRam *paletteRam) writeSpec(val byte) {
+    paletteRam.spec = val & 0xBF
+}
+
+func (paletteRam *paletteRam) readData() byte {
+    return paletteRam.data[paletteRam.spec&0x3F]
+}
+
+func (paletteRam *paletteRam) writeData(val byte) {
+    paletteRam.data[paletteRam.spec&0x3F] = val
+
+    if paletteRam.spec&0x80 != 0 {
+        paletteRam.spec = 0x80 | (paletteRam.spec+1)&0x3F
+    }
+}
+
+// A colour as the 15 bit little endian value the hardware stores: red in
+// the low five bits, then green, then blue
+func (paletteRam *paletteRam) color(palette int, index int) uint16 {
+    offset := palette*8 + index*2
+    return uint16(paletteRam.data[offset]) | uint16(paletteRam.data[offset+1])<<8
+}
+
+func (paletteRam *paletteRam) setColor(palette int, index int, val uint16) {
+    offset := palette*8 + index*2
+    paletteRam.data[offset] = byte(val)
+    paletteRam.data[offset+1] = byte(val >> 8)
+}
+
+// Spreads each five bit channel over eight bits so that 31 becomes 255
+func Rgb555(val uint16) color.RGBA {
+    expand := func(c uint16) uint8 {
+        c &= 0x1F
+        return uint8(c<<3 | c>>2)
+    }
+
+    return color.RGBA{expand(val), expand(val >> 5), expand(val >> 10), 0xFF}
+}
+
+// Looks up a shade in a DMG palette register, where each pair of bits maps
+// a colour number to a shade
+func dmgShade(palette byte, index int) int {
+    return int(palette>>(uint(index)*2)) & 0x03
+}
@@ -0,0 +1,439 @@
+package ppu
+
+import (
+    . "../ram"
+    "image"
+    "image/color"
+    "io"
+    "sort"
+)
+
+const (
+    ScreenWidth  = 160
+    ScreenHeight = 144
+)
+
+const (
+    lcdcLoc = 0xFF40
+    scyLoc  = 0xFF42
+    scxLoc  = 0xFF43
+    bgpLoc  = 0xFF47
+    obp0Loc = 0xFF48
+    obp1Loc = 0xFF49
+    wyLoc   = 0xFF4A
+    wxLoc   = 0xFF4B
+
+    oamLoc     = 0xFE00
+    oamEntries = 40
+    // The hardware only fetches this many sprites for each line
+    spritesPerLine = 10
+
+    // Each line takes 456 cycles, of which searching OAM and drawing take
+    // roughly the first 252. HBlank is the rest.
+    cyclesPerLine = 456
+    hblankStart   = 252
+    linesPerFrame = 154
+)
+
+// LCDC bits
+const (
+    lcdcBgEnable  = 0x01
+    lcdcObjEnable = 0x02
+    lcdcObjTall   = 0x04
+    lcdcBgMap     = 0x08
+    lcdcTileData  = 0x10
+    lcdcWinEnable = 0x20
+    lcdcWinMap    = 0x40
+    lcdcLcdEnable = 0x80
+)
+
+// Bits of a CGB BG map attribute, which has the same layout as the flags
+// byte of a sprite
+const (
+    attrPalette  = 0x07
+    attrBank     = 0x08
+    attrDmgObp1  = 0x10
+    attrXFlip    = 0x20
+    attrYFlip    = 0x40
+    attrPriority = 0x80
+)
+
+// Turns VRAM and OAM into pictures. There's no LCD timing yet, so a whole
+// frame is drawn at once from whatever the registers hold when it ends.
+type Ppu struct {
+    ram         *Ram
+    cgb         bool
+    bgPalettes  paletteRam
+    objPalettes paletteRam
+    screen      *image.RGBA
+    hblank      func()
+    vblank      func()
+
+    // How colours come out
+    dmgPalette DmgPalette
+    compat     bool
+    correction Correction
+    corrected  *[0x8000]color.RGBA
+
+    // The shade of every pixel on screen before it's coloured, which is what
+    // the SGB sees of the picture
+    shades [ScreenWidth * ScreenHeight]byte
+
+    // Per line scratch space, kept between lines to save allocating it
+    bgColors   [ScreenWidth]int
+    bgPriority [ScreenWidth]bool
+}
+
+type sprite struct {
+    y, x  int
+    tile  byte
+    flags byte
+    index int
+}
+
+// In CGB mode the colour palette registers are hooked and everything is
+// drawn from them, otherwise the DMG's shades are used
+func (ppu *Ppu) Init(ram *Ram, cgb bool) {
+    ppu.ram = ram
+    ppu.cgb = cgb
+    ppu.bgPalettes.init()
+    ppu.objPalettes.init()
+    ppu.screen = image.NewRGBA(image.Rect(0, 0, ScreenWidth, ScreenHeight))
+    ppu.hblank = nil
+    ppu.vblank = nil
+    ppu.dmgPalette = DmgShades
+    ppu.compat = false
+    ppu.SetCorrection(CorrectionNone)
+    ppu.clear()
+
+    if cgb {
+        ram.HookRead(bcpsLoc, ppu.bgPalettes.readSpec)
+        ram.HookWrite(bcpsLoc, ppu.bgPalettes.writeSpec)
+        ram.HookRead(bcpdLoc, ppu.bgPalettes.readData)
+        ram.HookWrite(bcpdLoc, ppu.bgPalettes.writeData)
+        ram.HookRead(ocpsLoc, ppu.objPalettes.readSpec)
+        ram.HookWrite(ocpsLoc, ppu.objPalettes.writeSpec)
+        ram.HookRead(ocpdLoc, ppu.objPalettes.readData)
+        ram.HookWrite(ocpdLoc, ppu.objPalettes.writeData)
+    }
+}
+
+// Sets a function to call at the start of HBlank on every visible line
+func (ppu *Ppu) SetHBlankHandler(handler func()) {
+    ppu.hblank = handler
+}
+
+// Sets a function to call when the LCD reaches line 144 and VBlank starts
+func (ppu *Ppu) SetVBlankHandler(handler func()) {
+    ppu.vblank = handler
+}
+
+// Moves the LCD on by cycles from the given point in the frame, calling the
+// HBlank handler for every line that reaches HBlank on the way and the
+// VBlank handler if VBlank starts, including in the next frame if it gets
+// that far
+func (ppu *Ppu) Advance(frameCycles int, cycles int) {
+    if ppu.ram.Peek(lcdcLoc)&lcdcLcdEnable == 0 {
+        return
+    }
+
+    end := frameCycles + cycles
+
+    for line := frameCycles / cyclesPerLine; line*cyclesPerLine < end; line++ {
+        start := line * cyclesPerLine
+
+        switch {
+        case line%linesPerFrame < ScreenHeight:
+            if at := start + hblankStart; ppu.hblank != nil && at >= frameCycles && at < end {
+                ppu.hblank()
+            }
+        case line%linesPerFrame == ScreenHeight:
+            if ppu.vblank != nil && start >= frameCycles {
+                ppu.vblank()
+            }
+        }
+    }
+}
+
+// What the LCD is showing
+func (ppu *Ppu) Screen() *image.RGBA {
+    return ppu.screen
+}
+
+// The shade of each pixel, 0 to 3 from lightest to darkest, a row at a time.
+// Only meaningful outside CGB mode.
+func (ppu *Ppu) Shades() []byte {
+    return ppu.shades[:]
+}
+
+// Whether colours come from the CGB palettes
+func (ppu *Ppu) Cgb() bool {
+    return ppu.cgb
+}
+
+// A switched off LCD shows white
+func (ppu *Ppu) clear() {
+    white := color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}
+    for y := 0; y < ScreenHeight; y++ {
+        for x := 0; x < ScreenWidth; x++ {
+            ppu.screen.SetRGBA(x, y, white)
+            ppu.shades[y*ScreenWidth+x] = 0
+        }
+    }
+}
+
+func (ppu *Ppu) RenderFrame() {
+    lcdc := ppu.ram.Peek(lcdcLoc)
+    if lcdc&lcdcLcdEnable == 0 {
+        ppu.clear()
+        return
+    }
+
+    // The window keeps its own line counter, which only moves on lines where
+    // the window was drawn
+    windowLine := 0
+
+    for ly := 0; ly < ScreenHeight; ly++ {
+        if ppu.renderBackground(ly, lcdc, windowLine) {
+            windowLine++
+        }
+
+        if lcdc&lcdcObjEnable != 0 {
+            ppu.renderSprites(ly, lcdc)
+        }
+    }
+}
+
+// Draws the background and window for one line, leaving the colour numbers
+// behind for the sprites to be tested against. Reports whether any of the
+// window was drawn.
+func (ppu *Ppu) renderBackground(ly int, lcdc byte, windowLine int) (window bool) {
+    scy := int(ppu.ram.Peek(scyLoc))
+    scx := int(ppu.ram.Peek(scxLoc))
+    wy := int(ppu.ram.Peek(wyLoc))
+    wx := int(ppu.ram.Peek(wxLoc)) - 7
+    bgp := ppu.ram.Peek(bgpLoc)
+
+    // On a DMG bit 0 blanks the background and the window, on a CGB it only
+    // takes away their priority over sprites
+    bgEnabled := ppu.cgb || lcdc&lcdcBgEnable != 0
+    windowVisible := bgEnabled && lcdc&lcdcWinEnable != 0 && ly >= wy && wx < ScreenWidth
+
+    for x := 0; x < ScreenWidth; x++ {
+        if !bgEnabled {
+            ppu.bgColors[x] = 0
+            ppu.bgPriority[x] = false
+            ppu.screen.SetRGBA(x, ly, ppu.bgColor(0, bgp, 0))
+            ppu.shades[ly*ScreenWidth+x] = byte(dmgShade(bgp, 0))
+            continue
+        }
+
+        var mapLoc, mapX, mapY int
+
+        if windowVisible && x >= wx {
+            window = true
+            mapLoc = 0x1800
+            if lcdc&lcdcWinMap != 0 {
+                mapLoc = 0x1C00
+            }
+            mapX = x - wx
+            mapY = windowLine
+        } else {
+            mapLoc = 0x1800
+            if lcdc&lcdcBgMap != 0 {
+                mapLoc = 0x1C00
+            }
+            mapX = (x + scx) & 0xFF
+            mapY = (ly + scy) & 0xFF
+        }
+
+        mapLoc += mapY/8*32 + mapX/8
+        tile := ppu.ram.Vram(0)[mapLoc]
+
+        // Attributes sit at the same place in bank 1 as the tile numbers do
+        // in bank 0
+        var attr byte
+        if ppu.cgb {
+            attr = ppu.ram.Vram(1)[mapLoc]
+        }
+
+        row, col := mapY%8, mapX%8
+        if attr&attrYFlip != 0 {
+            row = 7 - row
+        }
+        if attr&attrXFlip != 0 {
+            col = 7 - col
+        }
+
+        bank := 0
+        if attr&attrBank != 0 {
+            bank = 1
+        }
+
+        index := ppu.tilePixel(bank, ppu.bgTileLoc(tile, lcdc), row, col)
+        ppu.bgColors[x] = index
+        ppu.bgPriority[x] = attr&attrPriority != 0
+
+        ppu.screen.SetRGBA(x, ly, ppu.bgColor(attr, bgp, index))
+        ppu.shades[ly*ScreenWidth+x] = byte(dmgShade(bgp, index))
+    }
+
+    return
+}
+
+// Background tiles are numbered up from $8000 or, with LCDC bit 4 clear,
+// signed from $9000
+func (ppu *Ppu) bgTileLoc(tile byte, lcdc byte) int {
+    if lcdc&lcdcTileData != 0 {
+        return int(tile) * 16
+    }
+
+    return 0x1000 + int(int8(tile))*16
+}
+
+// The colour number of one pixel of a tile. Each row is two bytes, the first
+// holding the low bit of every pixel and the second the high bit.
+func (ppu *Ppu) tilePixel(bank int, tileLoc int, row int, col int) int {
+    vram := ppu.ram.Vram(bank)
+    lo := vram[tileLoc+row*2]
+    hi := vram[tileLoc+row*2+1]
+    bit := uint(7 - col)
+
+    return int(lo>>bit&1) | int(hi>>bit&1)<<1
+}
+
+// Up to ten sprites on the line, highest priority first. A DMG prefers the
+// sprite further left and then the one earlier in OAM, a CGB only goes by
+// OAM order.
+func (ppu *Ppu) lineSprites(ly int, height int) []sprite {
+    sprites := make([]sprite, 0, spritesPerLine)
+
+    for i := 0; i < oamEntries && len(sprites) < spritesPerLine; i++ {
+        loc := uint16(oamLoc + i*4)
+        y := int(ppu.ram.Peek(loc)) - 16
+
+        if ly < y || ly >= y+height {
+            continue
+        }
+
+        sprites = append(sprites, sprite{
+            y:     y,
+            x:     int(ppu.ram.Peek(loc+1)) - 8,
+            tile:  ppu.ram.Peek(loc + 2),
+            flags: ppu.ram.Peek(loc + 3),
+            index: i,
+        })
+    }
+
+    if !ppu.cgb {
+        sort.SliceStable(sprites, func(i, j int) bool { return sprites[i].x < sprites[j].x })
+    }
+
+    return sprites
+}
+
+func (ppu *Ppu) renderSprites(ly int, lcdc byte) {
+    height := 8
+    if lcdc&lcdcObjTall != 0 {
+        height = 16
+    }
+
+    sprites := ppu.lineSprites(ly, height)
+
+    for x := 0; x < ScreenWidth; x++ {
+        // The first sprite with a visible pixel here wins, even if the
+        // background then covers it up
+        for _, sprite := range sprites {
+            if x < sprite.x || x >= sprite.x+8 {
+                continue
+            }
+
+            index := ppu.spritePixel(sprite, ly, x, height)
+            if index == 0 {
+                continue
+            }
+
+            if ppu.bgWins(x, sprite, lcdc) {
+                break
+            }
+
+            ppu.screen.SetRGBA(x, ly, ppu.objColor(sprite.flags, index))
+            obp, _ := ppu.obp(sprite.flags)
+            ppu.shades[ly*ScreenWidth+x] = byte(dmgShade(obp, index))
+            break
+        }
+    }
+}
+
+func (ppu *Ppu) spritePixel(sprite sprite, ly int, x int, height int) int {
+    row, col := ly-sprite.y, x-sprite.x
+    if sprite.flags&attrYFlip != 0 {
+        row = height - 1 - row
+    }
+    if sprite.flags&attrXFlip != 0 {
+        col = 7 - col
+    }
+
+    // Tall sprites ignore the bottom bit of the tile number
+    tile := sprite.tile
+    if height == 16 {
+        tile &= 0xFE
+    }
+
+    bank := 0
+    if ppu.cgb && sprite.flags&attrBank != 0 {
+        bank = 1
+    }
+
+    return ppu.tilePixel(bank, int(tile)*16, row, col)
+}
+
+// Colour 0 of the background never covers a sprite. Otherwise a sprite goes
+// behind when its own priority bit says so or, on a CGB, when the BG map
+// attribute does, unless LCDC bit 0 is clear to put every sprite on top.
+func (ppu *Ppu) bgWins(x int, sprite sprite, lcdc byte) bool {
+    if ppu.bgColors[x] == 0 {
+        return false
+    }
+
+    if ppu.cgb {
+        if lcdc&lcdcBgEnable == 0 {
+            return false
+        }
+
+        return ppu.bgPriority[x] || sprite.flags&attrPriority != 0
+    }
+
+    return sprite.flags&attrPriority != 0
+}
+
+func (ppu *Ppu) SaveState(w io.Writer) error {
+    for _, palettes := range []*paletteRam{&ppu.bgPalettes, &ppu.objPalettes} {
+        if _, err := w.Write([]byte{palettes.spec}); err != nil {
+            return err
+        }
+
+        if _, err := w.Write(palettes.data[:]); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+func (ppu *Ppu) LoadState(r io.Reader) error {
+    for _, palettes := range []*paletteRam{&ppu.bgPalettes, &ppu.objPalettes} {
+        spec := make([]byte, 1)
+        if _, err := io.ReadFull(r, spec); err != nil {
+            return err
+        }
+
+        if _, err := io.ReadFull(r, palettes.data[:]); err != nil {
+            return err
+        }
+
+        palettes.spec = spec[0]
+    }
+
+    return nil
+}
@@ -0,0 +1,201 @@
+package profiler
+
+import (
+    "compress/gzip"
+    "io"
+    "os"
+    "time"
+)
+
+// Field numbers from pprof's profile.proto
+const (
+    profileSampleType        = 1
+    profileSample            = 2
+    profileMapping           = 3
+    profileLocation          = 4
+    profileFunction          = 5
+    profileStringTable       = 6
+    profileTimeNanos         = 9
+    profileDurationNanos     = 10
+    profilePeriodType        = 11
+    profilePeriod            = 12
+    profileDefaultSampleType = 14
+
+    valueTypeType = 1
+    valueTypeUnit = 2
+
+    sampleLocationId = 1
+    sampleValue      = 2
+
+    mappingId           = 1
+    mappingMemoryLimit  = 3
+    mappingFilename     = 5
+    mappingHasFunctions = 7
+
+    locationId        = 1
+    locationMappingId = 2
+    locationAddress   = 3
+    locationLine      = 4
+
+    lineFunctionId = 1
+
+    functionId         = 1
+    functionName       = 2
+    functionSystemName = 3
+)
+
+// Just enough of the protobuf wire format to write a profile
+type protoBuffer struct {
+    data []byte
+}
+
+func (buf *protoBuffer) varint(x uint64) {
+    for x >= 0x80 {
+        buf.data = append(buf.data, byte(x)|0x80)
+        x >>= 7
+    }
+
+    buf.data = append(buf.data, byte(x))
+}
+
+func (buf *protoBuffer) uint64Field(field int, x uint64) {
+    if x == 0 {
+        return
+    }
+
+    buf.varint(uint64(field) << 3)
+    buf.varint(x)
+}
+
+func (buf *protoBuffer) bytesField(field int, data []byte) {
+    buf.varint(uint64(field)<<3 | 2)
+    buf.varint(uint64(len(data)))
+    buf.data = append(buf.data, data...)
+}
+
+func (buf *protoBuffer) packedField(field int, xs []uint64) {
+    var packed protoBuffer
+    for _, x := range xs {
+        packed.varint(x)
+    }
+
+    buf.bytesField(field, packed.data)
+}
+
+// Builds up the string table, which every name in a profile points into
+type stringTable struct {
+    strings []string
+    ids     map[string]uint64
+}
+
+func (table *stringTable) id(s string) uint64 {
+    if table.ids == nil {
+        // The first entry has to be the empty string
+        table.strings = []string{""}
+        table.ids = map[string]uint64{"": 0}
+    }
+
+    if id, ok := table.ids[s]; ok {
+        return id
+    }
+
+    id := uint64(len(table.strings))
+    table.strings = append(table.strings, s)
+    table.ids[s] = id
+    return id
+}
+
+func (profiler *Profiler) WriteFile(path string) error {
+    file, err := os.Create(path)
+    if err != nil {
+        return err
+    }
+
+    if err := profiler.Write(file); err != nil {
+        file.Close()
+        return err
+    }
+
+    return file.Close()
+}
+
+// Writes a gzipped pprof profile with a sample for every address in every
+// call chain it ran under. Location addresses are bank<<16 | addr.
+func (profiler *Profiler) Write(w io.Writer) error {
+    var (
+        buf   protoBuffer
+        table stringTable
+    )
+
+    valueType := func(field int, kind string, unit string) {
+        var msg protoBuffer
+        msg.uint64Field(valueTypeType, table.id(kind))
+        msg.uint64Field(valueTypeUnit, table.id(unit))
+        buf.bytesField(field, msg.data)
+    }
+
+    valueType(profileSampleType, "instructions", "count")
+    valueType(profileSampleType, "cycles", "count")
+
+    var walk func(node *callNode, stack []uint64)
+    walk = func(node *callNode, stack []uint64) {
+        for loc, self := range node.self {
+            var msg protoBuffer
+            msg.packedField(sampleLocationId, append([]uint64{uint64(loc) + 1}, stack...))
+            msg.packedField(sampleValue, []uint64{uint64(self.instructions), uint64(self.cycles)})
+            buf.bytesField(profileSample, msg.data)
+        }
+
+        for site, child := range node.children {
+            walk(child, append([]uint64{uint64(site) + 1}, stack...))
+        }
+    }
+    walk(&profiler.root, nil)
+
+    // Everything is already symbolized, so pprof doesn't need to go looking
+    // for a binary
+    var mapping protoBuffer
+    mapping.uint64Field(mappingId, 1)
+    mapping.uint64Field(mappingMemoryLimit, 1<<24)
+    mapping.uint64Field(mappingFilename, table.id("rom"))
+    mapping.uint64Field(mappingHasFunctions, 1)
+    buf.bytesField(profileMapping, mapping.data)
+
+    for i, location := range profiler.locations {
+        var line protoBuffer
+        line.uint64Field(lineFunctionId, uint64(location.function)+1)
+
+        var msg protoBuffer
+        msg.uint64Field(locationId, uint64(i)+1)
+        msg.uint64Field(locationMappingId, 1)
+        msg.uint64Field(locationAddress, uint64(location.bank)<<16|uint64(location.addr))
+        msg.bytesField(locationLine, line.data)
+        buf.bytesField(profileLocation, msg.data)
+    }
+
+    for i, name := range profiler.functions {
+        var msg protoBuffer
+        msg.uint64Field(functionId, uint64(i)+1)
+        msg.uint64Field(functionName, table.id(name))
+        msg.uint64Field(functionSystemName, table.id(name))
+        buf.bytesField(profileFunction, msg.data)
+    }
+
+    buf.uint64Field(profileTimeNanos, uint64(profiler.started.UnixNano()))
+    buf.uint64Field(profileDurationNanos, uint64(time.Since(profiler.started)))
+    valueType(profilePeriodType, "cycles", "count")
+    buf.uint64Field(profilePeriod, 1)
+    buf.uint64Field(profileDefaultSampleType, table.id("cycles"))
+
+    // The string table goes last since everything above adds to it
+    for _, s := range table.strings {
+        buf.bytesField(profileStringTable, []byte(s))
+    }
+
+    gz := gzip.NewWriter(w)
+    if _, err := gz.Write(buf.data); err != nil {
+        return err
+    }
+
+    return gz.Close()
+}
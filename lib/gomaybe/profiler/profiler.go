@@ -0,0 +1,238 @@
+package profiler
+
+import (
+    . "../gameboy"
+    . "../symbols"
+    "fmt"
+    "strings"
+    "time"
+)
+
+const (
+    // Deepest call stack kept track of. Anything deeper is charged to the
+    // caller at this depth.
+    maxDepth = 256
+)
+
+type counts struct {
+    instructions int64
+    cycles       int64
+}
+
+// An instruction address in a particular bank
+type location struct {
+    bank     int
+    addr     uint16
+    function int
+}
+
+// One node in the call tree for each distinct chain of call sites leading
+// to it. Time spent in a routine is kept on the node for the chain it was
+// called through, so that the same routine called from different places
+// shows up separately in the call graph.
+type callNode struct {
+    // Location of the call that led here, -1 for the root
+    site     int
+    parent   *callNode
+    children map[int]*callNode
+    self     map[int]*counts
+}
+
+type frame struct {
+    node *callNode
+    // SP just after the call, where the return address is
+    sp uint16
+    // Function to charge code in this routine to when there are no symbols
+    entry int
+}
+
+// Counts the instructions and cycles executed at every address and keeps a
+// shadow call stack from CALL, RST and RET so that the results can be
+// viewed as a call graph with go tool pprof
+type Profiler struct {
+    gameBoy     *GameBoy
+    symbols     *Symbols
+    locations   []location
+    locationIds map[location]int
+    // Function of the symbol each bank and address is in, -1 for none
+    symbolIds   map[uint32]int
+    functions   []string
+    functionIds map[string]int
+    root        callNode
+    rootEntry   int
+    stack       []frame
+    started     time.Time
+}
+
+func (profiler *Profiler) Init(gameBoy *GameBoy) {
+    profiler.gameBoy = gameBoy
+    profiler.locations = nil
+    profiler.locationIds = make(map[location]int)
+    profiler.symbolIds = make(map[uint32]int)
+    profiler.functions = nil
+    profiler.functionIds = make(map[string]int)
+    profiler.root = callNode{site: -1}
+    profiler.stack = nil
+    profiler.started = time.Now()
+
+    pc := gameBoy.Cpu.PC()
+    profiler.rootEntry = profiler.function(entryName(gameBoy.BankAt(pc), pc))
+}
+
+// Names routines after the labels they're in rather than their entry points
+func (profiler *Profiler) SetSymbols(symbols *Symbols) {
+    profiler.symbols = symbols
+    profiler.symbolIds = make(map[uint32]int)
+}
+
+// Runs one instruction, the same as GameBoy.Step, and counts it
+func (profiler *Profiler) Step() (cycles int) {
+    cpu := &profiler.gameBoy.Cpu
+
+    pc := cpu.PC()
+    sp := cpu.SP()
+    bank := profiler.gameBoy.BankAt(pc)
+    opCode := profiler.gameBoy.Ram.Peek(pc)
+
+    cycles = profiler.gameBoy.Step()
+    if cycles < 0 {
+        return
+    }
+
+    node, entry := &profiler.root, profiler.rootEntry
+    if len(profiler.stack) > 0 {
+        top := profiler.stack[len(profiler.stack)-1]
+        node, entry = top.node, top.entry
+    }
+
+    loc := profiler.location(bank, pc, entry)
+
+    self, ok := node.self[loc]
+    if !ok {
+        self = &counts{}
+        if node.self == nil {
+            node.self = make(map[int]*counts)
+        }
+        node.self[loc] = self
+    }
+
+    self.instructions++
+    self.cycles += int64(cycles)
+
+    // Conditional calls and returns are only taken if they moved the stack
+    switch {
+    case isCall(opCode) && cpu.SP() == sp-2:
+        profiler.call(node, loc, cpu.SP())
+    case isReturn(opCode) && cpu.SP() == sp+2:
+        profiler.ret(cpu.SP())
+    }
+
+    return
+}
+
+func isCall(opCode byte) bool {
+    switch opCode {
+    case 0xCD, 0xC4, 0xCC, 0xD4, 0xDC:
+        return true
+    }
+
+    // RST
+    return opCode&0xC7 == 0xC7
+}
+
+func isReturn(opCode byte) bool {
+    switch opCode {
+    case 0xC9, 0xD9, 0xC0, 0xC8, 0xD0, 0xD8:
+        return true
+    }
+
+    return false
+}
+
+func (profiler *Profiler) call(caller *callNode, site int, sp uint16) {
+    // Frames at or below the new return address can't be returned to any
+    // more, which happens when code resets the stack pointer or drops a
+    // return address instead of returning
+    profiler.unwind(sp + 1)
+
+    if len(profiler.stack) >= maxDepth {
+        return
+    }
+
+    node, ok := caller.children[site]
+    if !ok {
+        node = &callNode{site: site, parent: caller}
+        if caller.children == nil {
+            caller.children = make(map[int]*callNode)
+        }
+        caller.children[site] = node
+    }
+
+    pc := profiler.gameBoy.Cpu.PC()
+    entry := profiler.function(entryName(profiler.gameBoy.BankAt(pc), pc))
+
+    profiler.stack = append(profiler.stack, frame{node, sp, entry})
+}
+
+func (profiler *Profiler) ret(sp uint16) {
+    profiler.unwind(sp)
+}
+
+// Drops every frame whose return address is below sp
+func (profiler *Profiler) unwind(sp uint16) {
+    for len(profiler.stack) > 0 && profiler.stack[len(profiler.stack)-1].sp < sp {
+        profiler.stack = profiler.stack[:len(profiler.stack)-1]
+    }
+}
+
+func (profiler *Profiler) location(bank int, addr uint16, entry int) int {
+    key := uint32(bank)<<16 | uint32(addr)
+
+    function, ok := profiler.symbolIds[key]
+    if !ok {
+        function = -1
+        if profiler.symbols != nil {
+            if symbol, ok := profiler.symbols.Containing(bank, addr); ok {
+                // Local labels belong to the routine they're in
+                name := symbol.Name
+                if i := strings.Index(name, "."); i > 0 {
+                    name = name[:i]
+                }
+                function = profiler.function(name)
+            }
+        }
+        profiler.symbolIds[key] = function
+    }
+
+    // Without a symbol the same code can belong to more than one routine,
+    // like a helper jumped into from several places, so it gets a location
+    // for each
+    if function < 0 {
+        function = entry
+    }
+
+    loc := location{bank, addr, function}
+    if id, ok := profiler.locationIds[loc]; ok {
+        return id
+    }
+
+    id := len(profiler.locations)
+    profiler.locations = append(profiler.locations, loc)
+    profiler.locationIds[loc] = id
+    return id
+}
+
+func (profiler *Profiler) function(name string) int {
+    if id, ok := profiler.functionIds[name]; ok {
+        return id
+    }
+
+    id := len(profiler.functions)
+    profiler.functions = append(profiler.functions, name)
+    profiler.functionIds[name] = id
+    return id
+}
+
+func entryName(bank int, addr uint16) string {
+    return fmt.Sprintf("$%.2x:%.4x", bank, addr)
+}
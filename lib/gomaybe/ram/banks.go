@@ -0,0 +1,132 @@
+package ram
+
+import (
+    "io"
+)
+
+const (
+    vbkLoc  = 0xFF4F
+    svbkLoc = 0xFF70
+
+    vramStart    = 0x8000
+    vramBankSize = 0x2000
+    vramBanks    = 2
+    wramStart    = 0xC000
+    wramBankSize = 0x1000
+    wramBanks    = 8
+
+    banksStateSize = 2 + vramBanks*vramBankSize + wramBanks*wramBankSize
+)
+
+// The CGB has two banks of VRAM at $8000, picked with VBK, and eight banks
+// of WRAM. Bank 0 of WRAM is always at $C000 and SVBK picks which of the
+// others is at $D000, with 0 meaning bank 1.
+//
+// Banking is off until EnableBanks is called, and then those regions are
+// kept here instead of in the flat memory.
+type banks struct {
+    enabled bool
+    vram    []byte
+    wram    []byte
+    vbk     byte
+    svbk    byte
+}
+
+func (ram *Ram) initBanks() {
+    ram.banks = banks{
+        vram: make([]byte, vramBanks*vramBankSize),
+        wram: make([]byte, wramBanks*wramBankSize),
+    }
+}
+
+// Switches on CGB VRAM and WRAM banking
+func (ram *Ram) EnableBanks() {
+    ram.banks.enabled = true
+
+    ram.HookRead(vbkLoc, func() byte { return 0xFE | ram.banks.vbk })
+    ram.HookWrite(vbkLoc, func(val byte) { ram.banks.vbk = val & 0x01 })
+    ram.HookRead(svbkLoc, func() byte { return 0xF8 | ram.banks.svbk })
+    ram.HookWrite(svbkLoc, func(val byte) { ram.banks.svbk = val & 0x07 })
+}
+
+// The VRAM bank the CPU sees at $8000
+func (ram *Ram) VramBank() int {
+    return int(ram.banks.vbk)
+}
+
+// The WRAM bank the CPU sees at $D000
+func (ram *Ram) WramBank() int {
+    if ram.banks.svbk == 0 {
+        return 1
+    }
+
+    return int(ram.banks.svbk)
+}
+
+// One whole bank of VRAM, whichever is mapped in. Without banking bank 0 is
+// the flat memory at $8000.
+func (ram *Ram) Vram(bank int) []byte {
+    if !ram.banks.enabled {
+        return ram.all[vramStart : vramStart+vramBankSize]
+    }
+
+    return ram.banks.vram[bank*vramBankSize : (bank+1)*vramBankSize]
+}
+
+// One whole bank of WRAM. Without banking bank 0 is the flat memory at
+// $C000 and any other bank is the memory at $D000.
+func (ram *Ram) Wram(bank int) []byte {
+    if !ram.banks.enabled {
+        if bank != 0 {
+            bank = 1
+        }
+        return ram.all[wramStart+bank*wramBankSize : wramStart+(bank+1)*wramBankSize]
+    }
+
+    return ram.banks.wram[bank*wramBankSize : (bank+1)*wramBankSize]
+}
+
+// Where a banked location is currently stored, or nil if it isn't banked
+func (ram *Ram) banked(loc uint16) *byte {
+    if !ram.banks.enabled {
+        return nil
+    }
+
+    switch {
+    case loc >= vramStart && loc < vramStart+vramBankSize:
+        return &ram.banks.vram[ram.VramBank()*vramBankSize+int(loc-vramStart)]
+    case loc >= wramStart && loc < wramStart+wramBankSize:
+        return &ram.banks.wram[loc-wramStart]
+    case loc >= wramStart+wramBankSize && loc < wramStart+2*wramBankSize:
+        return &ram.banks.wram[ram.WramBank()*wramBankSize+int(loc-wramStart-wramBankSize)]
+    }
+
+    return nil
+}
+
+func (ram *Ram) SaveBanks(w io.Writer) error {
+    if _, err := w.Write([]byte{ram.banks.vbk, ram.banks.svbk}); err != nil {
+        return err
+    }
+
+    if _, err := w.Write(ram.banks.vram); err != nil {
+        return err
+    }
+
+    _, err := w.Write(ram.banks.wram)
+    return err
+}
+
+func (ram *Ram) LoadBanks(r io.Reader) error {
+    state := make([]byte, banksStateSize)
+    if _, err := io.ReadFull(r, state); err != nil {
+        return err
+    }
+
+    ram.banks.vbk, ram.banks.svbk = state[0]&0x01, state[1]&0x07
+    state = state[2:]
+    copy(ram.banks.vram, state[:len(ram.banks.vram)])
+    copy(ram.banks.wram, state[len(ram.banks.vram):])
+
+    return nil
+}
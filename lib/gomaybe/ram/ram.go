@@ -5,8 +5,13 @@ import (
 )
 
 type Ram struct {
-    all     []byte
-    startUp bool
+    all        []byte
+    startUp    bool
+    readHooks  map[uint16]func() byte
+    writeHooks map[uint16]func(val byte)
+    watcher    func(loc uint16, val byte, write bool)
+    filter     func(loc uint16, val byte) byte
+    banks      banks
 }
 
 var startUpRom = []byte{
@@ -31,14 +36,77 @@ var startUpRom = []byte{
 func (ram *Ram) Init() {
     ram.all = make([]byte, 0x10000, 0x10000)
     ram.startUp = true
+    ram.readHooks = make(map[uint16]func() byte)
+    ram.writeHooks = make(map[uint16]func(val byte))
+    ram.initBanks()
+
+    // The boot ROM unmaps itself by writing to this register when it's done
+    ram.HookWrite(0xFF50, func(val byte) {
+        if val != 0 {
+            ram.startUp = false
+        }
+    })
+}
+
+func (ram *Ram) SkipBoot() {
+    ram.startUp = false
+}
+
+// Hardware registers hook the locations they live at so reads and writes go
+// to them instead of plain memory
+func (ram *Ram) HookRead(loc uint16, hook func() byte) {
+    ram.readHooks[loc] = hook
+}
+
+func (ram *Ram) HookWrite(loc uint16, hook func(val byte)) {
+    ram.writeHooks[loc] = hook
+}
+
+// The watcher sees every read and write that goes through Read and Write,
+// for debugging. Pass nil to remove it.
+func (ram *Ram) SetWatcher(watcher func(loc uint16, val byte, write bool)) {
+    ram.watcher = watcher
+}
+
+// The filter can change any value read from memory before anything sees
+// it, like a cheat device between the cartridge and the console. Pass nil to
+// remove it.
+func (ram *Ram) SetReadFilter(filter func(loc uint16, val byte) byte) {
+    ram.filter = filter
+}
+
+func (ram *Ram) Read(loc uint16) (val byte) {
+    val = ram.Peek(loc)
+
+    if ram.watcher != nil {
+        ram.watcher(loc, val, false)
+    }
+
+    return
 }
 
-func (ram *Ram) Read(loc uint16) byte {
+// Reads without the watcher seeing it, for hardware other than the CPU
+// looking at memory
+func (ram *Ram) Peek(loc uint16) (val byte) {
+    // The boot ROM is inside the console, out of reach of anything on the
+    // cartridge bus
     if ram.startUp && loc <= 0xFF {
         return startUpRom[loc]
     }
 
-    return ram.all[loc]
+    if hook, ok := ram.readHooks[loc]; ok {
+        val = hook()
+    } else if banked := ram.banked(loc); banked != nil {
+        val = *banked
+    } else {
+        val = ram.all[loc]
+    }
+
+    if ram.filter != nil {
+        val = ram.filter(loc, val)
+    }
+
+    return
 }
 
 func (ram *Ram) ReadWord(loc uint16) uint16 {
@@ -50,13 +118,27 @@ func (ram *Ram) ReadWordSplit(loc uint16) (byte, byte) {
 }
 
 func (ram *Ram) Write(loc uint16, val byte) {
+    if ram.watcher != nil {
+        ram.watcher(loc, val, true)
+    }
+
+    if hook, ok := ram.writeHooks[loc]; ok {
+        hook(val)
+        return
+    }
+
+    if banked := ram.banked(loc); banked != nil {
+        *banked = val
+        return
+    }
+
     ram.all[loc] = val
 }
 
 func (ram *Ram) WriteWord(loc uint16, val uint16) {
     most, least := util.W2B(val)
-    ram.all[loc] = least
-    ram.all[loc+1] = most
+    ram.Write(loc, least)
+    ram.Write(loc+1, most)
 }
 
 func (ram *Ram) WriteBlock(loc uint16, vals []byte) {
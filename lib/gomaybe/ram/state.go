@@ -0,0 +1,35 @@
+package ram
+
+import (
+    "io"
+)
+
+// Size in bytes of the serialized memory: the boot ROM flag followed by the
+// full address space
+const stateSize = 1 + 0x10000
+
+func (ram *Ram) SaveState(w io.Writer) error {
+    var startUp byte
+    if ram.startUp {
+        startUp = 1
+    }
+
+    if _, err := w.Write([]byte{startUp}); err != nil {
+        return err
+    }
+
+    _, err := w.Write(ram.all)
+    return err
+}
+
+func (ram *Ram) LoadState(r io.Reader) error {
+    state := make([]byte, stateSize)
+    if _, err := io.ReadFull(r, state); err != nil {
+        return err
+    }
+
+    ram.startUp = state[0] != 0
+    copy(ram.all, state[1:])
+
+    return nil
+}
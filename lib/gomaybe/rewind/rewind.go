@@ -0,0 +1,151 @@
+package rewind
+
+import (
+    "bytes"
+    "compress/flate"
+    "io/ioutil"
+)
+
+// Keeps a bounded history of save states for stepping backwards in time.
+//
+// Only the newest state is kept whole. Each older state is stored as the
+// deflated XOR of itself against the state that followed it, which is mostly
+// zeros since little memory changes from one frame to the next. Rewinding
+// peels deltas off the newest end and the oldest ones are dropped once the
+// memory budget is exceeded.
+type Rewind struct {
+    budget    int
+    interval  int
+    countdown int
+    last      []byte
+    deltas    [][]byte
+    size      int
+}
+
+// The budget is in bytes and the interval is the number of frames between
+// snapshots. A budget of 0 disables rewinding.
+func (rewind *Rewind) Init(budget int, interval int) {
+    if interval < 1 {
+        interval = 1
+    }
+
+    rewind.budget = budget
+    rewind.interval = interval
+    rewind.Reset()
+}
+
+func (rewind *Rewind) Reset() {
+    rewind.countdown = 0
+    rewind.last = nil
+    rewind.deltas = nil
+    rewind.size = 0
+}
+
+func (rewind *Rewind) Enabled() bool {
+    return rewind.budget > 0
+}
+
+// Counts a frame and reports whether a snapshot should be taken for it
+func (rewind *Rewind) Due() bool {
+    if !rewind.Enabled() {
+        return false
+    }
+
+    if rewind.countdown > 0 {
+        rewind.countdown--
+        return false
+    }
+
+    rewind.countdown = rewind.interval - 1
+    return true
+}
+
+// Number of snapshots that can be stepped back through
+func (rewind *Rewind) Len() int {
+    if rewind.last == nil {
+        return 0
+    }
+
+    return len(rewind.deltas) + 1
+}
+
+// Bytes of memory currently used by the history
+func (rewind *Rewind) Size() int {
+    return rewind.size
+}
+
+func (rewind *Rewind) Push(state []byte) {
+    if !rewind.Enabled() {
+        return
+    }
+
+    // States of different shapes can't be diffed, start over
+    if rewind.last != nil && len(rewind.last) != len(state) {
+        rewind.Reset()
+    }
+
+    if rewind.last != nil {
+        delta := deflate(xor(rewind.last, state))
+        rewind.deltas = append(rewind.deltas, delta)
+        rewind.size += len(delta) - len(rewind.last)
+    }
+
+    rewind.last = append([]byte(nil), state...)
+    rewind.size += len(rewind.last)
+
+    for rewind.size > rewind.budget && len(rewind.deltas) > 0 {
+        rewind.size -= len(rewind.deltas[0])
+        rewind.deltas[0] = nil
+        rewind.deltas = rewind.deltas[1:]
+    }
+}
+
+// Returns the newest snapshot and removes it from the history
+func (rewind *Rewind) Pop() ([]byte, bool) {
+    if rewind.last == nil {
+        return nil, false
+    }
+
+    state := rewind.last
+    rewind.size -= len(state)
+    rewind.last = nil
+
+    if n := len(rewind.deltas); n > 0 {
+        delta := rewind.deltas[n-1]
+        rewind.deltas = rewind.deltas[:n-1]
+        rewind.size -= len(delta)
+
+        rewind.last = xor(state, inflate(delta))
+        rewind.size += len(rewind.last)
+    }
+
+    rewind.countdown = 0
+    return state, true
+}
+
+func xor(a []byte, b []byte) []byte {
+    result := make([]byte, len(a))
+    for i := range result {
+        result[i] = a[i] ^ b[i]
+    }
+
+    return result
+}
+
+func deflate(data []byte) []byte {
+    var buf bytes.Buffer
+
+    writer, _ := flate.NewWriter(&buf, flate.BestSpeed)
+    writer.Write(data)
+    writer.Close()
+
+    return buf.Bytes()
+}
+
+func inflate(data []byte) []byte {
+    reader := flate.NewReader(bytes.NewReader(data))
+    defer reader.Close()
+
+    result, _ := ioutil.ReadAll(reader)
+    return result
+}
@@ -2,29 +2,72 @@ package rom
 
 import (
     . "../ram"
-    "fmt"
+    "strings"
 )
 
+const (
+    titleStart        = 0x0134
+    titleEnd          = 0x0143
+    cartridgeTypeAddr = 0x0147
+    // Largest ROM that fits without a memory bank controller
+    plainRomSize = 0x8000
+)
+
+// Well beyond the biggest cartridge, for catching nonsense sizes in patches
+// and archives before trying to allocate them
+const MaxSize = 16 << 20
+
 type Rom struct {
     title         string
     cartridgeType byte
 }
 
+// romData can be any length. Anything missing from a truncated header reads
+// as zero.
 func (rom *Rom) Init(romData []byte, ram *Ram) {
-    rom.title = string(romData[0x0134:0x0142])
-    fmt.Println("Title: " + rom.title)
+    title := make([]byte, titleEnd-titleStart)
+    if len(romData) > titleStart {
+        copy(title, romData[titleStart:])
+    }
+    rom.title = strings.TrimRight(string(title), "\x00")
 
-    rom.cartridgeType = romData[0x0147]
-    fmt.Println("Cartridge: " + cartridgeTypeStr(rom.cartridgeType))
+    rom.cartridgeType = 0
+    if len(romData) > cartridgeTypeAddr {
+        rom.cartridgeType = romData[cartridgeTypeAddr]
+    }
+
+    if rom.Supported() {
+        if len(romData) > plainRomSize {
+            romData = romData[:plainRomSize]
+        }
 
-    switch rom.cartridgeType {
-    case 0x00:
         ram.WriteBlock(0x0000, romData)
-    default:
-        fmt.Printf("Don't know how to handle cartridge type %.2X\n", rom.cartridgeType)
     }
 }
 
+func (rom *Rom) Title() string {
+    return rom.title
+}
+
+func (rom *Rom) CartridgeType() byte {
+    return rom.cartridgeType
+}
+
+func (rom *Rom) CartridgeName() string {
+    return cartridgeTypeStr(rom.cartridgeType)
+}
+
+// Whether the cartridge hardware is emulated
+func (rom *Rom) Supported() bool {
+    return rom.cartridgeType == 0x00
+}
+
+// The ROM bank mapped at $4000-$7FFF. Without a memory bank controller
+// that's always bank 1.
+func (rom *Rom) Bank() int {
+    return 1
+}
+
 func cartridgeTypeStr(cartridgeType byte) (cartridgeTypeStr string) {
     switch cartridgeType {
     case 0x00:
@@ -0,0 +1,27 @@
+package rom
+
+import (
+    . "../ram"
+    "testing"
+)
+
+func FuzzInit(f *testing.F) {
+    f.Add([]byte{})
+    f.Add(make([]byte, 0x0140))
+    f.Add(make([]byte, 0x8000))
+    f.Add(make([]byte, 0x10001))
+
+    f.Fuzz(func(t *testing.T, romData []byte) {
+        var (
+            ram Ram
+            rom Rom
+        )
+
+        ram.Init()
+        rom.Init(romData, &ram)
+
+        if len(rom.Title()) > titleEnd-titleStart {
+            t.Errorf("title %q is longer than the header allows", rom.Title())
+        }
+    })
+}
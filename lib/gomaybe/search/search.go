@@ -0,0 +1,133 @@
+package search
+
+import (
+    . "../ram"
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+// Where games keep their variables: cartridge RAM, WRAM and HRAM
+var regions = [][2]int{
+    {0xA000, 0xE000},
+    {0xFF80, 0xFFFF},
+}
+
+type Candidate struct {
+    Addr uint16
+    // The value when the search was last narrowed and what's there now
+    Prev int
+    Cur  int
+}
+
+// Finds where a game keeps a value by watching how memory changes. Start
+// takes a snapshot of every address, then each Filter keeps only the
+// addresses whose values changed the right way since the last one.
+type Search struct {
+    ram        *Ram
+    size       int
+    candidates []Candidate
+}
+
+func (search *Search) Init(ram *Ram) {
+    search.ram = ram
+    search.size = 1
+    search.candidates = nil
+}
+
+// Starts again with every address, reading size bytes at each, 1 or 2.
+// 16 bit values are little endian.
+func (search *Search) Start(size int) {
+    search.size = size
+    search.candidates = nil
+
+    for _, region := range regions {
+        for addr := region[0]; addr+size <= region[1]; addr++ {
+            val := search.read(uint16(addr))
+            search.candidates = append(search.candidates, Candidate{uint16(addr), val, val})
+        }
+    }
+}
+
+// Bytes per value
+func (search *Search) Size() int {
+    return search.size
+}
+
+func (search *Search) read(addr uint16) int {
+    if search.size == 2 {
+        return int(search.ram.Peek(addr)) | int(search.ram.Peek(addr+1))<<8
+    }
+
+    return int(search.ram.Peek(addr))
+}
+
+// Reads the current value at every candidate
+func (search *Search) Candidates() []Candidate {
+    for i := range search.candidates {
+        search.candidates[i].Cur = search.read(search.candidates[i].Addr)
+    }
+
+    return search.candidates
+}
+
+// Keeps the candidates for which keep returns true, given the value at the
+// last filter and now. What's there now becomes the value to compare
+// against next time. Returns how many are left.
+func (search *Search) Filter(keep func(prev int, cur int) bool) int {
+    kept := search.candidates[:0]
+
+    for _, candidate := range search.Candidates() {
+        if keep(candidate.Prev, candidate.Cur) {
+            candidate.Prev = candidate.Cur
+            kept = append(kept, candidate)
+        }
+    }
+
+    search.candidates = kept
+    return len(kept)
+}
+
+// Parses a comparison: one of == != < > <= >= compares with the previous
+// value, or with N if one is given, and +N or -N looks for values that went
+// up or down by exactly N
+func ParseComparison(op string, operand string) (func(prev int, cur int) bool, error) {
+    if (strings.HasPrefix(op, "+") || strings.HasPrefix(op, "-")) && len(op) > 1 {
+        by, err := strconv.ParseInt(op, 0, 32)
+        if err != nil {
+            return nil, errors.New("invalid difference " + op)
+        }
+
+        return func(prev int, cur int) bool { return cur-prev == int(by) }, nil
+    }
+
+    against, hasOperand := 0, operand != ""
+    if hasOperand {
+        val, err := strconv.ParseInt(strings.Replace(operand, "$", "0x", 1), 0, 32)
+        if err != nil {
+            return nil, errors.New("invalid value " + operand)
+        }
+        against = int(val)
+    }
+
+    compare := map[string]func(a int, b int) bool{
+        "==": func(a int, b int) bool { return a == b },
+        "!=": func(a int, b int) bool { return a != b },
+        "<":  func(a int, b int) bool { return a < b },
+        ">":  func(a int, b int) bool { return a > b },
+        "<=": func(a int, b int) bool { return a <= b },
+        ">=": func(a int, b int) bool { return a >= b },
+    }[op]
+
+    if compare == nil {
+        return nil, fmt.Errorf("unknown comparison %q", op)
+    }
+
+    return func(prev int, cur int) bool {
+        if hasOperand {
+            return compare(cur, against)
+        }
+        return compare(cur, prev)
+    }, nil
+}
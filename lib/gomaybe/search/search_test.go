@@ -0,0 +1,38 @@
+package search
+
+import (
+    "testing"
+)
+
+func TestParseComparison(t *testing.T) {
+    tests := []struct {
+        op, operand string
+        prev, cur   int
+        want        bool
+    }{
+        {"==", "", 5, 5, true},
+        {"<", "", 5, 4, true},
+        {">=", "$10", 0, 0x10, true},
+        {"!=", "3", 3, 3, false},
+        // A negative operand is still compared with, not taken as no operand
+        {"==", "-1", 7, 7, false},
+        {">", "-1", 7, 0, true},
+        {"+2", "", 7, 9, true},
+        {"-1", "", 7, 5, false},
+    }
+
+    for _, test := range tests {
+        compare, err := ParseComparison(test.op, test.operand)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        if got := compare(test.prev, test.cur); got != test.want {
+            t.Errorf("%s %s with %d then %d got %v", test.op, test.operand, test.prev, test.cur, got)
+        }
+    }
+
+    if _, err := ParseComparison("=<", ""); err == nil {
+        t.Error("=< parsed")
+    }
+}
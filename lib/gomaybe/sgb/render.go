@@ -0,0 +1,114 @@
+package sgb
+
+import (
+    . "../ppu"
+    "image"
+    "image/color"
+)
+
+const (
+    DisplayWidth  = 256
+    DisplayHeight = 224
+
+    // Where the Game Boy's screen sits inside the border
+    screenX = (DisplayWidth - ScreenWidth) / 2
+    screenY = (DisplayHeight - ScreenHeight) / 2
+
+    borderRows = DisplayHeight / 8
+)
+
+func (sgb *Sgb) initScreens() {
+    sgb.game = image.NewRGBA(image.Rect(0, 0, ScreenWidth, ScreenHeight))
+    sgb.display = image.NewRGBA(image.Rect(0, 0, DisplayWidth, DisplayHeight))
+    sgb.render()
+}
+
+// The whole TV picture: the border with the coloured Game Boy screen in the
+// middle
+func (sgb *Sgb) Display() *image.RGBA {
+    return sgb.display
+}
+
+// Colours the PPU's screen with the SGB palettes, or masks it, and draws the
+// border around a copy of it
+func (sgb *Sgb) render() {
+    screen := sgb.ppu.Screen()
+    shades := sgb.ppu.Shades()
+    state := &sgb.state
+
+    for y := 0; y < ScreenHeight; y++ {
+        for x := 0; x < ScreenWidth; x++ {
+            var c color.RGBA
+
+            switch state.Mask {
+            case maskOff:
+                palette := state.Attrs[y/8*cellsWide+x/8]
+                c = Rgb555(state.Palettes[palette][shades[y*ScreenWidth+x]])
+                sgb.game.SetRGBA(x, y, c)
+            case maskFreeze:
+                c = sgb.game.RGBAAt(x, y)
+            case maskBlack:
+                c = color.RGBA{0, 0, 0, 0xFF}
+            case maskColor0:
+                c = Rgb555(state.Palettes[0][0])
+            }
+
+            screen.SetRGBA(x, y, c)
+        }
+    }
+
+    sgb.renderBorder()
+
+    for y := 0; y < ScreenHeight; y++ {
+        for x := 0; x < ScreenWidth; x++ {
+            sgb.display.SetRGBA(screenX+x, screenY+y, screen.RGBAAt(x, y))
+        }
+    }
+}
+
+// The border is 32x28 SNES tiles. Each map entry has the tile number in the
+// bottom eight bits, the palette in bits 10 to 12, counting from 4, and the
+// flips in bits 14 and 15. Colour 0 shows the backdrop, which is SGB colour 0.
+func (sgb *Sgb) renderBorder() {
+    state := &sgb.state
+    backdrop := Rgb555(state.Palettes[0][0])
+
+    for row := 0; row < borderRows; row++ {
+        for col := 0; col < 32; col++ {
+            entry := word(state.BorderMap[:], (row*32+col)*2)
+            tile := int(entry & 0xFF)
+            palette := int(entry>>10&0x07) - 4
+
+            for y := 0; y < 8; y++ {
+                for x := 0; x < 8; x++ {
+                    tileX, tileY := x, y
+                    if entry&0x4000 != 0 {
+                        tileX = 7 - x
+                    }
+                    if entry&0x8000 != 0 {
+                        tileY = 7 - y
+                    }
+
+                    c := backdrop
+                    if index := sgb.borderPixel(tile, tileX, tileY); index != 0 && palette >= 0 {
+                        c = Rgb555(state.BorderPalettes[palette][index])
+                    }
+
+                    sgb.display.SetRGBA(col*8+x, row*8+y, c)
+                }
+            }
+        }
+    }
+}
+
+// SNES tiles have four bit planes. The first two are interleaved a row at a
+// time like a Game Boy tile and the other two follow the same way.
+func (sgb *Sgb) borderPixel(tile int, x int, y int) int {
+    data := sgb.state.Tiles[tile*32:]
+    bit := uint(7 - x)
+
+    return int(data[y*2]>>bit&1) |
+        int(data[y*2+1]>>bit&1)<<1 |
+        int(data[16+y*2]>>bit&1)<<2 |
+        int(data[16+y*2+1]>>bit&1)<<3
+}
@@ -0,0 +1,425 @@
+package sgb
+
+import (
+    . "../joypad"
+    . "../ppu"
+    "encoding/binary"
+    "image"
+    "io"
+)
+
+const (
+    packetSize = 16
+    packetBits = packetSize * 8
+
+    // The screen is coloured in 8x8 cells
+    cellsWide = ScreenWidth / 8
+    cellsHigh = ScreenHeight / 8
+    cells     = cellsWide * cellsHigh
+
+    systemPalettes = 512
+    attrFiles      = 45
+    attrFileSize   = cells / 4
+
+    borderTiles   = 256
+    borderMapSize = 32 * 32 * 2
+
+    // VRAM transfers copy 4KiB off the screen
+    transferSize = 0x1000
+)
+
+// Commands, from the top five bits of the first byte of a packet
+const (
+    cmdPal01   = 0x00
+    cmdPal23   = 0x01
+    cmdPal03   = 0x02
+    cmdPal12   = 0x03
+    cmdAttrBlk = 0x04
+    cmdAttrLin = 0x05
+    cmdAttrDiv = 0x06
+    cmdAttrChr = 0x07
+    cmdPalSet  = 0x0A
+    cmdPalTrn  = 0x0B
+    cmdMltReq  = 0x11
+    cmdChrTrn  = 0x13
+    cmdPctTrn  = 0x14
+    cmdAttrTrn = 0x15
+    cmdAttrSet = 0x16
+    cmdMaskEn  = 0x17
+)
+
+// MASK_EN settings
+const (
+    maskOff = iota
+    maskFreeze
+    maskBlack
+    maskColor0
+)
+
+// Everything the SGB remembers, laid out to be saved as it is
+type sgbState struct {
+    // Colour 0 is shared by all four palettes
+    Palettes       [4][4]uint16
+    System         [systemPalettes][4]uint16
+    Attrs          [cells]byte
+    AttrFiles      [attrFiles][attrFileSize]byte
+    Tiles          [borderTiles * 32]byte
+    BorderMap      [borderMapSize]byte
+    BorderPalettes [4][16]uint16
+    Mask           byte
+    // A *_TRN command waiting for the next frame to copy from
+    Transfer       byte
+    TransferArg    byte
+}
+
+// The Super Game Boy. Games talk to it with 16 byte packets sent a bit at a
+// time through JOYP, and it colours the picture it gets from the Game Boy
+// and puts a border around it.
+type Sgb struct {
+    ppu    *Ppu
+    joypad *Joypad
+    state  sgbState
+
+    // The packet being received
+    receiving bool
+    waitHigh  bool
+    bits      int
+    packet    [packetSize]byte
+    // Packets of a command that spans more than one
+    packets   [][packetSize]byte
+
+    // The coloured screen as of the last unmasked frame, and the whole
+    // picture with the border
+    game    *image.RGBA
+    display *image.RGBA
+}
+
+// Until a game sets its own, everything uses palette 1-A
+var defaultPalette = [4]uint16{0x67BF, 0x265B, 0x10B5, 0x2866}
+
+func (sgb *Sgb) Init(joypad *Joypad, ppu *Ppu) {
+    sgb.ppu = ppu
+    sgb.joypad = joypad
+    sgb.state = sgbState{}
+    for i := range sgb.state.Palettes {
+        sgb.state.Palettes[i] = defaultPalette
+    }
+    sgb.receiving = false
+    sgb.packets = nil
+    sgb.initScreens()
+
+    joypad.SetWriteHandler(sgb.write)
+}
+
+// Each bit is a pulse on P14 for 0 or P15 for 1, with both released
+// between bits. Pulling both low starts a packet, and it ends with a 0 bit
+// after the 128 data bits.
+func (sgb *Sgb) write(val byte) {
+    switch val & 0x30 {
+    case 0x00:
+        sgb.receiving = true
+        sgb.waitHigh = true
+        sgb.bits = 0
+        sgb.packet = [packetSize]byte{}
+    case 0x30:
+        sgb.waitHigh = false
+    default:
+        if !sgb.receiving || sgb.waitHigh {
+            return
+        }
+        sgb.waitHigh = true
+
+        if sgb.bits == packetBits {
+            sgb.receiving = false
+            sgb.receive(sgb.packet)
+            return
+        }
+
+        if val&0x20 == 0 {
+            sgb.packet[sgb.bits/8] |= 1 << uint(sgb.bits%8)
+        }
+        sgb.bits++
+    }
+}
+
+// The first packet of a command says how many there are in its bottom
+// three bits
+func (sgb *Sgb) receive(packet [packetSize]byte) {
+    sgb.packets = append(sgb.packets, packet)
+
+    length := int(sgb.packets[0][0] & 0x07)
+    if length == 0 {
+        sgb.packets = nil
+        return
+    }
+
+    if len(sgb.packets) < length {
+        return
+    }
+
+    var data []byte
+    for _, packet := range sgb.packets {
+        data = append(data, packet[:]...)
+    }
+    sgb.packets = nil
+
+    sgb.command(data[0]>>3, data)
+}
+
+func (sgb *Sgb) command(cmd byte, data []byte) {
+    state := &sgb.state
+
+    switch cmd {
+    case cmdPal01, cmdPal23, cmdPal03, cmdPal12:
+        pairs := [][2]int{{0, 1}, {2, 3}, {0, 3}, {1, 2}}
+        pair := pairs[cmd]
+
+        sgb.setColor0(word(data, 1))
+        for i := 1; i < 4; i++ {
+            state.Palettes[pair[0]][i] = word(data, 1+i*2)
+            state.Palettes[pair[1]][i] = word(data, 7+i*2)
+        }
+    case cmdAttrBlk:
+        sgb.attrBlk(data)
+    case cmdAttrLin:
+        sgb.attrLin(data)
+    case cmdAttrDiv:
+        sgb.attrDiv(data)
+    case cmdAttrChr:
+        sgb.attrChr(data)
+    case cmdPalSet:
+        for i := 0; i < 4; i++ {
+            palette := state.System[word(data, 1+i*2)%systemPalettes]
+            state.Palettes[i] = palette
+        }
+        sgb.setColor0(state.Palettes[0][0])
+
+        if data[9]&0x80 != 0 {
+            sgb.attrSet(data[9])
+        } else if data[9]&0x40 != 0 {
+            state.Mask = maskOff
+        }
+    case cmdAttrSet:
+        sgb.attrSet(data[1] | 0x80)
+    case cmdMltReq:
+        players := []int{1, 2, 1, 4}
+        sgb.joypad.SetPlayers(players[data[1]&0x03])
+    case cmdPalTrn, cmdChrTrn, cmdPctTrn, cmdAttrTrn:
+        state.Transfer = cmd
+        state.TransferArg = data[1]
+    case cmdMaskEn:
+        state.Mask = data[1] & 0x03
+    }
+}
+
+func word(data []byte, offset int) uint16 {
+    return binary.LittleEndian.Uint16(data[offset:])
+}
+
+func (sgb *Sgb) setColor0(val uint16) {
+    for i := range sgb.state.Palettes {
+        sgb.state.Palettes[i][0] = val
+    }
+}
+
+// Applies a stored attribute file, whose number is in the bottom six bits.
+// Bit 6 also turns off the screen mask.
+func (sgb *Sgb) attrSet(arg byte) {
+    file := int(arg & 0x3F)
+    if file < attrFiles {
+        for i := range sgb.state.Attrs {
+            sgb.state.Attrs[i] = sgb.state.AttrFiles[file][i/4] >> uint(6-i%4*2) & 0x03
+        }
+    }
+
+    if arg&0x40 != 0 {
+        sgb.state.Mask = maskOff
+    }
+}
+
+// Rectangles, each with a palette for inside, outside and the border
+// between them
+func (sgb *Sgb) attrBlk(data []byte) {
+    sets := int(data[1])
+
+    for i := 0; i < sets && 2+i*6+6 <= len(data); i++ {
+        set := data[2+i*6:]
+        control := set[0] & 0x07
+        inside, border, outside := set[1]&0x03, set[1]>>2&0x03, set[1]>>4&0x03
+        x1, y1, x2, y2 := int(set[2]&0x1F), int(set[3]&0x1F), int(set[4]&0x1F), int(set[5]&0x1F)
+
+        // Setting only one side of the border colours the border with it
+        switch control {
+        case 0x01:
+            border, control = inside, 0x03
+        case 0x04:
+            border, control = outside, 0x06
+        }
+
+        for y := 0; y < cellsHigh; y++ {
+            for x := 0; x < cellsWide; x++ {
+                switch {
+                case x > x1 && x < x2 && y > y1 && y < y2:
+                    if control&0x01 != 0 {
+                        sgb.state.Attrs[y*cellsWide+x] = inside
+                    }
+                case x < x1 || x > x2 || y < y1 || y > y2:
+                    if control&0x04 != 0 {
+                        sgb.state.Attrs[y*cellsWide+x] = outside
+                    }
+                default:
+                    if control&0x02 != 0 {
+                        sgb.state.Attrs[y*cellsWide+x] = border
+                    }
+                }
+            }
+        }
+    }
+}
+
+// Whole rows or columns, one byte each: the line in the bottom five bits,
+// the palette in the next two and bit 7 set for a row
+func (sgb *Sgb) attrLin(data []byte) {
+    sets := int(data[1])
+
+    for i := 0; i < sets && 2+i < len(data); i++ {
+        line := int(data[2+i] & 0x1F)
+        palette := data[2+i] >> 5 & 0x03
+
+        if data[2+i]&0x80 != 0 {
+            for x := 0; x < cellsWide && line < cellsHigh; x++ {
+                sgb.state.Attrs[line*cellsWide+x] = palette
+            }
+        } else {
+            for y := 0; y < cellsHigh && line < cellsWide; y++ {
+                sgb.state.Attrs[y*cellsWide+line] = palette
+            }
+        }
+    }
+}
+
+// Splits the screen at a row or column, with palettes for either side and
+// the line itself
+func (sgb *Sgb) attrDiv(data []byte) {
+    after, before, on := data[1]&0x03, data[1]>>2&0x03, data[1]>>4&0x03
+    at := int(data[2] & 0x1F)
+    horizontal := data[1]&0x40 != 0
+
+    for y := 0; y < cellsHigh; y++ {
+        for x := 0; x < cellsWide; x++ {
+            pos := x
+            if horizontal {
+                pos = y
+            }
+
+            palette := on
+            if pos < at {
+                palette = before
+            } else if pos > at {
+                palette = after
+            }
+
+            sgb.state.Attrs[y*cellsWide+x] = palette
+        }
+    }
+}
+
+// A run of cells from a starting point, going across or down and wrapping
+// at the edge, with two bits of palette for each
+func (sgb *Sgb) attrChr(data []byte) {
+    x, y := int(data[1]), int(data[2])
+    count := int(word(data, 3))
+    down := data[5] != 0
+
+    for i := 0; i < count && 6+i/4 < len(data) && x < cellsWide && y < cellsHigh; i++ {
+        sgb.state.Attrs[y*cellsWide+x] = data[6+i/4] >> uint(6-i%4*2) & 0x03
+
+        if down {
+            if y++; y == cellsHigh {
+                y = 0
+                x++
+            }
+        } else {
+            if x++; x == cellsWide {
+                x = 0
+                y++
+            }
+        }
+    }
+}
+
+// Called at the end of every frame, after the PPU has drawn it
+func (sgb *Sgb) Frame() {
+    if sgb.state.Transfer != 0 {
+        sgb.transfer(sgb.state.Transfer, sgb.state.TransferArg)
+        sgb.state.Transfer = 0
+    }
+
+    sgb.render()
+}
+
+// The SGB only sees what's on screen, so games fill the first 256 tiles of
+// the BG map with the data and let it show for a frame. The data comes back
+// out of the shades, 20 tiles to a row.
+func (sgb *Sgb) transfer(cmd byte, arg byte) {
+    shades := sgb.ppu.Shades()
+    data := make([]byte, transferSize)
+
+    for tile := 0; tile < transferSize/16; tile++ {
+        tileX, tileY := tile%cellsWide*8, tile/cellsWide*8
+
+        for row := 0; row < 8; row++ {
+            var lo, hi byte
+
+            for col := 0; col < 8; col++ {
+                shade := shades[(tileY+row)*ScreenWidth+tileX+col]
+                lo |= (shade & 1) << uint(7-col)
+                hi |= (shade >> 1 & 1) << uint(7-col)
+            }
+
+            data[tile*16+row*2] = lo
+            data[tile*16+row*2+1] = hi
+        }
+    }
+
+    state := &sgb.state
+
+    switch cmd {
+    case cmdPalTrn:
+        for i := range state.System {
+            for j := range state.System[i] {
+                state.System[i][j] = word(data, i*8+j*2)
+            }
+        }
+    case cmdChrTrn:
+        // Half the border tiles at a time
+        copy(state.Tiles[int(arg&0x01)*transferSize:], data)
+    case cmdPctTrn:
+        copy(state.BorderMap[:], data)
+        for i := range state.BorderPalettes {
+            for j := range state.BorderPalettes[i] {
+                state.BorderPalettes[i][j] = word(data, borderMapSize+i*32+j*2)
+            }
+        }
+    case cmdAttrTrn:
+        for i := range state.AttrFiles {
+            copy(state.AttrFiles[i][:], data[i*attrFileSize:])
+        }
+    }
+}
+
+func (sgb *Sgb) SaveState(w io.Writer) error {
+    return binary.Write(w, binary.LittleEndian, &sgb.state)
+}
+
+func (sgb *Sgb) LoadState(r io.Reader) error {
+    var state sgbState
+    if err := binary.Read(r, binary.LittleEndian, &state); err != nil {
+        return err
+    }
+
+    sgb.state = state
+    sgb.receiving = false
+    sgb.packets = nil
+    return nil
+}
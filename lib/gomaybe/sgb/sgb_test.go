@@ -0,0 +1,122 @@
+package sgb
+
+import (
+    . "../joypad"
+    . "../ppu"
+    . "../ram"
+    "testing"
+)
+
+func newSgb() *Sgb {
+    var (
+        ram    Ram
+        joypad Joypad
+        ppu    Ppu
+        sgb    Sgb
+    )
+
+    ram.Init()
+    joypad.Init(&ram)
+    ppu.Init(&ram, false)
+    sgb.Init(&joypad, &ppu)
+    return &sgb
+}
+
+// Sends a packet the way a game does: a reset pulse, the bits least
+// significant first, and the 0 stop bit, with both lines released between
+// each
+func sendPacket(sgb *Sgb, packet []byte) {
+    pulse := func(val byte) {
+        sgb.write(val)
+        sgb.write(0x30)
+    }
+
+    pulse(0x00)
+
+    for i := 0; i < packetBits; i++ {
+        if i/8 < len(packet) && packet[i/8]>>uint(i%8)&1 != 0 {
+            pulse(0x10)
+        } else {
+            pulse(0x20)
+        }
+    }
+
+    pulse(0x20)
+}
+
+func TestPal01(t *testing.T) {
+    sgb := newSgb()
+
+    sendPacket(sgb, []byte{
+        cmdPal01<<3 | 1,
+        0x11, 0x00,
+        0x01, 0x01, 0x02, 0x01, 0x03, 0x01,
+        0x01, 0x02, 0x02, 0x02, 0x03, 0x02,
+    })
+
+    palettes := sgb.state.Palettes
+    if want := [4]uint16{0x0011, 0x0101, 0x0102, 0x0103}; palettes[0] != want {
+        t.Errorf("palette 0 is %04x, want %04x", palettes[0], want)
+    }
+
+    if want := [4]uint16{0x0011, 0x0201, 0x0202, 0x0203}; palettes[1] != want {
+        t.Errorf("palette 1 is %04x, want %04x", palettes[1], want)
+    }
+
+    // Colour 0 is shared, the rest is left alone
+    if want := [4]uint16{0x0011, defaultPalette[1], defaultPalette[2], defaultPalette[3]}; palettes[2] != want {
+        t.Errorf("palette 2 is %04x, want %04x", palettes[2], want)
+    }
+}
+
+func TestAttrBlkInside(t *testing.T) {
+    sgb := newSgb()
+
+    // One block from (2,2) to (5,5) with only inside set, to palette 2
+    sendPacket(sgb, []byte{cmdAttrBlk<<3 | 1, 1, 0x01, 0x02, 2, 2, 5, 5})
+
+    for y := 0; y < cellsHigh; y++ {
+        for x := 0; x < cellsWide; x++ {
+            want := byte(0)
+            if x >= 2 && x <= 5 && y >= 2 && y <= 5 {
+                want = 2
+            }
+
+            if got := sgb.state.Attrs[y*cellsWide+x]; got != want {
+                t.Errorf("cell (%d,%d) has palette %d, want %d", x, y, got, want)
+            }
+        }
+    }
+}
+
+func TestAttrChrWrap(t *testing.T) {
+    sgb := newSgb()
+
+    // 80 cells of palette 3 across from (18,3), which needs data from the
+    // second packet
+    first := []byte{cmdAttrChr<<3 | 2, 18, 3, 80, 0, 0}
+    for len(first) < packetSize {
+        first = append(first, 0xFF)
+    }
+    second := make([]byte, packetSize)
+    for i := range second {
+        second[i] = 0xFF
+    }
+
+    sendPacket(sgb, first)
+    if sgb.state.Attrs[3*cellsWide+18] != 0 {
+        t.Fatal("ATTR_CHR applied before its second packet")
+    }
+    sendPacket(sgb, second)
+
+    for i := 0; i < cells; i++ {
+        want := byte(0)
+        if i >= 3*cellsWide+18 && i < 3*cellsWide+18+80 {
+            want = 3
+        }
+
+        if got := sgb.state.Attrs[i]; got != want {
+            t.Errorf("cell (%d,%d) has palette %d, want %d", i%cellsWide, i/cellsWide, got, want)
+        }
+    }
+}
@@ -0,0 +1,75 @@
+package speed
+
+import (
+    . "../ram"
+    "io"
+)
+
+const key1Loc = 0xFF4D
+
+// The CGB's speed switch. Writing 1 to bit 0 of KEY1 arms it and the next
+// STOP toggles between normal and double speed. Bit 7 reads back the
+// current speed.
+type Speed struct {
+    double bool
+    armed  bool
+}
+
+func (speed *Speed) Init(ram *Ram) {
+    speed.double = false
+    speed.armed = false
+
+    ram.HookRead(key1Loc, speed.read)
+    ram.HookWrite(key1Loc, speed.write)
+}
+
+// Whether the CPU is running at double speed
+func (speed *Speed) Double() bool {
+    return speed.double
+}
+
+// Called when the CPU executes STOP. Reports whether the speed changed.
+func (speed *Speed) Switch() bool {
+    if !speed.armed {
+        return false
+    }
+
+    speed.double = !speed.double
+    speed.armed = false
+    return true
+}
+
+func (speed *Speed) read() byte {
+    // The unused bits read as 1
+    val := byte(0x7E)
+
+    if speed.double {
+        val |= 0x80
+    }
+
+    if speed.armed {
+        val |= 0x01
+    }
+
+    return val
+}
+
+func (speed *Speed) write(val byte) {
+    speed.armed = val&0x01 != 0
+}
+
+func (speed *Speed) SaveState(w io.Writer) error {
+    _, err := w.Write([]byte{speed.read()})
+    return err
+}
+
+func (speed *Speed) LoadState(r io.Reader) error {
+    state := make([]byte, 1)
+    if _, err := io.ReadFull(r, state); err != nil {
+        return err
+    }
+
+    speed.double = state[0]&0x80 != 0
+    speed.armed = state[0]&0x01 != 0
+    return nil
+}
@@ -0,0 +1,191 @@
+package symbols
+
+import (
+    . "../disasm"
+    "bufio"
+    "fmt"
+    "io"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+type Symbol struct {
+    Bank int
+    Addr uint16
+    Name string
+}
+
+// Labels from an RGBDS .sym file, which has one "bank:addr name" entry per
+// line in hex, e.g.
+//
+//     01:4000 main_loop
+//
+// Comments start with a semicolon.
+type Symbols struct {
+    byName   map[string]Symbol
+    byAddr   map[int]map[uint16]string
+    sorted   map[int][]Symbol
+    unsorted bool
+}
+
+func (symbols *Symbols) Init() {
+    symbols.byName = make(map[string]Symbol)
+    symbols.byAddr = make(map[int]map[uint16]string)
+    symbols.sorted = make(map[int][]Symbol)
+}
+
+func (symbols *Symbols) LoadFile(path string) error {
+    file, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    return symbols.Load(file)
+}
+
+func (symbols *Symbols) Load(r io.Reader) error {
+    scanner := bufio.NewScanner(r)
+
+    for lineNum := 1; scanner.Scan(); lineNum++ {
+        line := scanner.Text()
+        if i := strings.Index(line, ";"); i >= 0 {
+            line = line[:i]
+        }
+
+        fields := strings.Fields(line)
+        if len(fields) == 0 {
+            continue
+        }
+
+        loc := strings.SplitN(fields[0], ":", 2)
+        if len(fields) < 2 || len(loc) < 2 {
+            return fmt.Errorf("line %d: expected bank:addr name", lineNum)
+        }
+
+        bank, err := strconv.ParseUint(loc[0], 16, 16)
+        if err != nil {
+            return fmt.Errorf("line %d: bad bank %q", lineNum, loc[0])
+        }
+
+        addr, err := strconv.ParseUint(loc[1], 16, 16)
+        if err != nil {
+            return fmt.Errorf("line %d: bad address %q", lineNum, loc[1])
+        }
+
+        symbols.Add(Symbol{int(bank), uint16(addr), fields[1]})
+    }
+
+    return scanner.Err()
+}
+
+func (symbols *Symbols) Add(symbol Symbol) {
+    symbols.byName[symbol.Name] = symbol
+
+    addrs, ok := symbols.byAddr[symbol.Bank]
+    if !ok {
+        addrs = make(map[uint16]string)
+        symbols.byAddr[symbol.Bank] = addrs
+    }
+
+    // When several labels share an address, prefer a global one over the
+    // local labels under it
+    if existing, ok := addrs[symbol.Addr]; !ok || strings.Contains(existing, ".") && !strings.Contains(symbol.Name, ".") {
+        addrs[symbol.Addr] = symbol.Name
+    }
+
+    symbols.sorted[symbol.Bank] = append(symbols.sorted[symbol.Bank], symbol)
+    symbols.unsorted = true
+}
+
+func (symbols *Symbols) Len() int {
+    return len(symbols.byName)
+}
+
+func (symbols *Symbols) Lookup(name string) (Symbol, bool) {
+    symbol, ok := symbols.byName[name]
+    return symbol, ok
+}
+
+// The label at exactly bank:addr
+func (symbols *Symbols) Name(bank int, addr uint16) (string, bool) {
+    name, ok := symbols.byAddr[bank][addr]
+    return name, ok
+}
+
+// The closest label at or before bank:addr in the same region of memory,
+// written as label+offset when it isn't exact
+func (symbols *Symbols) Locate(bank int, addr uint16) (string, bool) {
+    if name, ok := symbols.Name(bank, addr); ok {
+        return name, true
+    }
+
+    symbol, ok := symbols.Containing(bank, addr)
+    if !ok {
+        return "", false
+    }
+
+    return fmt.Sprintf("%s+%d", symbol.Name, addr-symbol.Addr), true
+}
+
+// The closest label at or before bank:addr in the same region of memory
+func (symbols *Symbols) Containing(bank int, addr uint16) (Symbol, bool) {
+    if symbols.unsorted {
+        for _, sorted := range symbols.sorted {
+            sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Addr < sorted[j].Addr })
+        }
+        symbols.unsorted = false
+    }
+
+    sorted := symbols.sorted[bank]
+    i := sort.Search(len(sorted), func(i int) bool { return sorted[i].Addr > addr }) - 1
+    if i < 0 || region(sorted[i].Addr) != region(addr) {
+        return Symbol{}, false
+    }
+
+    return sorted[i], true
+}
+
+// Which part of the memory map an address falls in, so that a label in ROM
+// doesn't get used to describe an address in RAM
+func region(addr uint16) int {
+    switch {
+    case addr < 0x4000:
+        return 0
+    case addr < 0x8000:
+        return 1
+    case addr < 0xA000:
+        return 2
+    case addr < 0xC000:
+        return 3
+    case addr < 0xD000:
+        return 4
+    case addr < 0xE000:
+        return 5
+    case addr < 0xFF80:
+        return 6
+    }
+
+    return 7
+}
+
+// Names addresses as seen from the CPU, using bankAt to find out which bank
+// is mapped at an address
+func (symbols *Symbols) Namer(bankAt func(addr uint16) int) Namer {
+    return &bankedNamer{symbols, bankAt}
+}
+
+type bankedNamer struct {
+    symbols *Symbols
+    bankAt  func(addr uint16) int
+}
+
+func (bankedNamer *bankedNamer) Name(addr uint16) (string, bool) {
+    return bankedNamer.symbols.Name(bankedNamer.bankAt(addr), addr)
+}
+
+func (bankedNamer *bankedNamer) Locate(addr uint16) (string, bool) {
+    return bankedNamer.symbols.Locate(bankedNamer.bankAt(addr), addr)
+}